@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// Actions recorded in the audit trail
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+)
+
+// EntityTransaction is the entity name of transaction events
+const EntityTransaction = "transaction"
+
+// ignoredFields change on every write and are left out of the diff
+var ignoredFields = map[string]bool{
+	"id":         true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+// Change is the value of one field before and after an event, null when the field did not exist
+type Change struct {
+	From json.RawMessage `json:"from"`
+	To   json.RawMessage `json:"to"`
+}
+
+// Event is one change to an entity. Before is nil for a create.
+type Event struct {
+	LedgerID int
+	ActorID  int
+	Entity   string
+	EntityID int
+	Action   string
+	Before   any
+	After    any
+	IP       string
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// Diff compares the JSON fields of before and after and returns the fields that differ
+func Diff(before, after any) (map[string]Change, error) {
+	beforeFields, err := fields(before)
+	if err != nil {
+		return nil, err
+	}
+	afterFields, err := fields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]Change{}
+	for name, to := range afterFields {
+		if ignoredFields[name] {
+			continue
+		}
+		from, ok := beforeFields[name]
+		if !ok {
+			from = json.RawMessage("null")
+		}
+		if !bytes.Equal(from, to) {
+			changes[name] = Change{From: from, To: to}
+		}
+	}
+	for name, from := range beforeFields {
+		if _, ok := afterFields[name]; !ok && !ignoredFields[name] {
+			changes[name] = Change{From: from, To: json.RawMessage("null")}
+		}
+	}
+	return changes, nil
+}
+
+func fields(value any) (map[string]json.RawMessage, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Record stores an event, inside the DB transaction of the change when ex is a *sql.Tx,
+// so the change and its audit event are committed together
+func Record(ex execer, event Event) error {
+	changes, err := Diff(event.Before, event.After)
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	var ip *string
+	if event.IP != "" {
+		ip = &event.IP
+	}
+	_, err = ex.Exec(`
+		INSERT INTO swordfish.audit_events (ledger_id, actor_id, entity, entity_id, action, changes, ip, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, event.LedgerID, event.ActorID, event.Entity, event.EntityID, event.Action, raw, ip, time.Now())
+	return err
+}
@@ -0,0 +1,23 @@
+-- category groups, user defined buckets of transaction categories used by the quarter report
+CREATE TABLE IF NOT EXISTS swordfish.category_groups (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	name VARCHAR(64) NOT NULL,
+	categories TEXT[] NOT NULL DEFAULT '{}',
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS category_groups_user_id_name_idx
+	ON swordfish.category_groups (user_id, LOWER(name));
+
+-- the groups the quarter report used to have built in, new users get them on registration
+INSERT INTO swordfish.category_groups (user_id, name, categories)
+SELECT u.id, g.name, g.categories
+FROM swordfish.users AS u
+CROSS JOIN (VALUES
+	('essentials', ARRAY['makan', 'cafe', 'utils', 'errand', 'bensin', 'olahraga']),
+	('non-essentials', ARRAY['misc', 'family', 'transport', 'traveling', 'healthcare', 'date']),
+	('shopping', ARRAY['belanja'])
+) AS g (name, categories)
+ON CONFLICT (user_id, LOWER(name)) DO NOTHING;
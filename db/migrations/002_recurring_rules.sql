@@ -0,0 +1,28 @@
+-- recurring rules, materialized into swordfish.transactions by the in-process scheduler
+CREATE TABLE IF NOT EXISTS swordfish.recurring_rules (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	type VARCHAR(16) NOT NULL,
+	amount INTEGER NOT NULL,
+	category VARCHAR(64) NOT NULL,
+	notes TEXT,
+	frequency VARCHAR(16) NOT NULL,
+	repeat_interval INTEGER NOT NULL DEFAULT 1,
+	start_date DATE NOT NULL,
+	end_date DATE,
+	count INTEGER,
+	occurrences INTEGER NOT NULL DEFAULT 0,
+	next_date DATE,
+	is_active BOOLEAN NOT NULL DEFAULT true,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS recurring_rules_next_date_idx
+	ON swordfish.recurring_rules (next_date) WHERE is_active = true;
+
+-- one transaction per rule and occurrence date, makes the scheduler idempotent
+ALTER TABLE swordfish.transactions ADD COLUMN IF NOT EXISTS recurring_rule_id INTEGER REFERENCES swordfish.recurring_rules(id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS transactions_recurring_rule_id_date_idx
+	ON swordfish.transactions (recurring_rule_id, date) WHERE recurring_rule_id IS NOT NULL;
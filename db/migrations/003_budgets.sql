@@ -0,0 +1,12 @@
+-- monthly budget per category, month is stored as the first day of the month
+CREATE TABLE IF NOT EXISTS swordfish.budgets (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	category VARCHAR(64) NOT NULL,
+	month DATE NOT NULL,
+	amount INTEGER NOT NULL,
+	rollover BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	UNIQUE (user_id, category, month)
+);
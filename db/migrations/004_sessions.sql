@@ -0,0 +1,23 @@
+-- login sessions, every access token carries its session id as jti
+CREATE TABLE IF NOT EXISTS swordfish.sessions (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP,
+	user_agent TEXT,
+	ip VARCHAR(64),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	last_used_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON swordfish.sessions (user_id);
+
+-- rotating refresh tokens, only the sha256 hash is stored
+CREATE TABLE IF NOT EXISTS swordfish.refresh_tokens (
+	id SERIAL PRIMARY KEY,
+	session_id VARCHAR(64) NOT NULL REFERENCES swordfish.sessions(id),
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,9 @@
+-- single-use password reset tokens, only the sha256 hash is stored
+CREATE TABLE IF NOT EXISTS swordfish.password_resets (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
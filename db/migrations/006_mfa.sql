@@ -0,0 +1,19 @@
+-- TOTP two-factor authentication, enabled_at stays NULL until the first code is confirmed
+CREATE TABLE IF NOT EXISTS swordfish.user_mfa (
+	user_id INTEGER PRIMARY KEY REFERENCES swordfish.users(id),
+	secret VARCHAR(64) NOT NULL,
+	enabled_at TIMESTAMP,
+	last_used_step BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- single-use recovery codes, only the sha256 hash is stored
+CREATE TABLE IF NOT EXISTS swordfish.mfa_recovery_codes (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	code_hash VARCHAR(64) NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS mfa_recovery_codes_user_id_idx ON swordfish.mfa_recovery_codes (user_id);
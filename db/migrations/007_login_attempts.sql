@@ -0,0 +1,12 @@
+-- every login attempt, used for backoff/lockout and shown to the user
+CREATE TABLE IF NOT EXISTS swordfish.login_attempts (
+	id SERIAL PRIMARY KEY,
+	email VARCHAR(255) NOT NULL,
+	ip VARCHAR(64) NOT NULL,
+	user_agent TEXT,
+	success BOOLEAN NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_email_created_at_idx ON swordfish.login_attempts (LOWER(email), created_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_created_at_idx ON swordfish.login_attempts (ip, created_at);
@@ -0,0 +1,15 @@
+-- personal API keys, only the sha256 hash is stored, prefix is kept to recognize the key
+CREATE TABLE IF NOT EXISTS swordfish.api_keys (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	name VARCHAR(64) NOT NULL,
+	prefix VARCHAR(16) NOT NULL,
+	key_hash VARCHAR(64) NOT NULL UNIQUE,
+	read_only BOOLEAN NOT NULL DEFAULT false,
+	expires_at TIMESTAMP,
+	last_used_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON swordfish.api_keys (user_id);
@@ -0,0 +1,9 @@
+-- scopes of personal API keys, read-only keys keep only the read scopes
+ALTER TABLE swordfish.api_keys ADD COLUMN IF NOT EXISTS scopes TEXT[] NOT NULL DEFAULT '{}';
+
+UPDATE swordfish.api_keys
+SET scopes = CASE
+	WHEN read_only THEN ARRAY['transactions:read', 'reports:read', 'assets:read']
+	ELSE ARRAY['transactions:read', 'transactions:write', 'reports:read', 'budgets:write', 'assets:read', 'assets:write']
+END
+WHERE scopes = '{}';
@@ -0,0 +1,68 @@
+-- shared ledgers, every user has a personal ledger and can be invited to others
+CREATE TABLE IF NOT EXISTS swordfish.ledgers (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(64) NOT NULL,
+	is_personal BOOLEAN NOT NULL DEFAULT false,
+	created_by INTEGER NOT NULL REFERENCES swordfish.users(id),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS ledgers_personal_idx ON swordfish.ledgers (created_by) WHERE is_personal;
+
+CREATE TABLE IF NOT EXISTS swordfish.ledger_members (
+	ledger_id INTEGER NOT NULL REFERENCES swordfish.ledgers(id) ON DELETE CASCADE,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	role VARCHAR(16) NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (ledger_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS ledger_members_user_id_idx ON swordfish.ledger_members (user_id);
+
+CREATE TABLE IF NOT EXISTS swordfish.ledger_invitations (
+	id SERIAL PRIMARY KEY,
+	ledger_id INTEGER NOT NULL REFERENCES swordfish.ledgers(id) ON DELETE CASCADE,
+	email VARCHAR(255) NOT NULL,
+	role VARCHAR(16) NOT NULL CHECK (role IN ('editor', 'viewer')),
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	invited_by INTEGER NOT NULL REFERENCES swordfish.users(id),
+	expires_at TIMESTAMP NOT NULL,
+	accepted_at TIMESTAMP,
+	revoked_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- personal ledger for the existing users
+INSERT INTO swordfish.ledgers (name, is_personal, created_by)
+SELECT 'Personal', true, u.id
+FROM swordfish.users AS u
+WHERE NOT EXISTS (SELECT 1 FROM swordfish.ledgers AS l WHERE l.created_by = u.id AND l.is_personal);
+
+INSERT INTO swordfish.ledger_members (ledger_id, user_id, role)
+SELECT id, created_by, 'owner' FROM swordfish.ledgers WHERE is_personal
+ON CONFLICT DO NOTHING;
+
+-- existing data moves to the personal ledger of its user, user_id stays as the author
+ALTER TABLE swordfish.transactions ADD COLUMN IF NOT EXISTS ledger_id INTEGER REFERENCES swordfish.ledgers(id);
+UPDATE swordfish.transactions AS t SET ledger_id = l.id
+FROM swordfish.ledgers AS l WHERE l.is_personal AND l.created_by = t.user_id AND t.ledger_id IS NULL;
+ALTER TABLE swordfish.transactions ALTER COLUMN ledger_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS transactions_ledger_id_date_idx ON swordfish.transactions (ledger_id, date);
+
+ALTER TABLE swordfish.assets ADD COLUMN IF NOT EXISTS ledger_id INTEGER REFERENCES swordfish.ledgers(id);
+UPDATE swordfish.assets AS a SET ledger_id = l.id
+FROM swordfish.ledgers AS l WHERE l.is_personal AND l.created_by = a.user_id AND a.ledger_id IS NULL;
+ALTER TABLE swordfish.assets ALTER COLUMN ledger_id SET NOT NULL;
+
+ALTER TABLE swordfish.recurring_rules ADD COLUMN IF NOT EXISTS ledger_id INTEGER REFERENCES swordfish.ledgers(id);
+UPDATE swordfish.recurring_rules AS r SET ledger_id = l.id
+FROM swordfish.ledgers AS l WHERE l.is_personal AND l.created_by = r.user_id AND r.ledger_id IS NULL;
+ALTER TABLE swordfish.recurring_rules ALTER COLUMN ledger_id SET NOT NULL;
+
+ALTER TABLE swordfish.budgets ADD COLUMN IF NOT EXISTS ledger_id INTEGER REFERENCES swordfish.ledgers(id);
+UPDATE swordfish.budgets AS b SET ledger_id = l.id
+FROM swordfish.ledgers AS l WHERE l.is_personal AND l.created_by = b.user_id AND b.ledger_id IS NULL;
+ALTER TABLE swordfish.budgets ALTER COLUMN ledger_id SET NOT NULL;
+ALTER TABLE swordfish.budgets DROP CONSTRAINT IF EXISTS budgets_user_id_category_month_key;
+ALTER TABLE swordfish.budgets ADD CONSTRAINT budgets_ledger_id_category_month_key UNIQUE (ledger_id, category, month);
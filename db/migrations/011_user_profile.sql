@@ -0,0 +1,13 @@
+-- pending email changes, the new email only replaces the old one once its link is opened
+CREATE TABLE IF NOT EXISTS swordfish.email_changes (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	email VARCHAR(255) NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	expires_at TIMESTAMP NOT NULL,
+	used_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- deleted accounts are anonymized, the row stays as the author of shared ledger data
+ALTER TABLE swordfish.users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
@@ -0,0 +1,4 @@
+-- accounts registered before email verification existed count as verified
+ALTER TABLE swordfish.users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP;
+
+UPDATE swordfish.users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
@@ -0,0 +1,13 @@
+-- data export archives of users, built in the background and downloadable until expires_at
+CREATE TABLE IF NOT EXISTS swordfish.data_exports (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'ready', 'failed', 'expired')),
+	file_path TEXT,
+	error TEXT,
+	expires_at TIMESTAMP,
+	completed_at TIMESTAMP,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_user_id_idx ON swordfish.data_exports (user_id);
@@ -0,0 +1,20 @@
+-- external identities linked to users, one per issuer and subject
+CREATE TABLE IF NOT EXISTS swordfish.user_identities (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	issuer TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	email VARCHAR(255),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	last_login_at TIMESTAMP,
+	UNIQUE (issuer, subject)
+);
+
+-- pending OpenID Connect logins, the state is only stored hashed
+CREATE TABLE IF NOT EXISTS swordfish.oidc_logins (
+	state_hash VARCHAR(64) PRIMARY KEY,
+	code_verifier VARCHAR(128) NOT NULL,
+	nonce VARCHAR(64) NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,5 @@
+-- deleted transactions stay in the trash until they are restored or purged
+ALTER TABLE swordfish.transactions ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+UPDATE swordfish.transactions SET deleted_at = updated_at WHERE is_active = false AND deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS transactions_deleted_at_idx ON swordfish.transactions (deleted_at) WHERE is_active = false;
@@ -0,0 +1,27 @@
+-- append-only audit trail of changes to ledger data
+CREATE TABLE IF NOT EXISTS swordfish.audit_events (
+	id BIGSERIAL PRIMARY KEY,
+	ledger_id INTEGER NOT NULL REFERENCES swordfish.ledgers(id),
+	actor_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	entity VARCHAR(32) NOT NULL,
+	entity_id INTEGER NOT NULL,
+	action VARCHAR(16) NOT NULL CHECK (action IN ('create', 'update', 'delete', 'restore')),
+	changes JSONB NOT NULL DEFAULT '{}',
+	ip VARCHAR(45),
+	created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_entity_idx ON swordfish.audit_events (entity, entity_id);
+CREATE INDEX IF NOT EXISTS audit_events_ledger_id_idx ON swordfish.audit_events (ledger_id, id);
+
+-- events are never edited, they are only deleted together with their ledger when an account is deleted
+CREATE OR REPLACE FUNCTION swordfish.audit_events_immutable() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit events are immutable';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS audit_events_immutable ON swordfish.audit_events;
+CREATE TRIGGER audit_events_immutable
+	BEFORE UPDATE ON swordfish.audit_events
+	FOR EACH ROW EXECUTE FUNCTION swordfish.audit_events_immutable();
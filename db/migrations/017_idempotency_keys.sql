@@ -0,0 +1,11 @@
+-- responses of create requests sent with an Idempotency-Key, replayed when the client retries
+CREATE TABLE IF NOT EXISTS swordfish.idempotency_keys (
+	user_id INTEGER NOT NULL REFERENCES swordfish.users(id),
+	key VARCHAR(255) NOT NULL,
+	request_hash VARCHAR(64) NOT NULL,
+	response_status INTEGER,
+	response_body BYTEA,
+	created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (user_id, key)
+);
@@ -0,0 +1,9 @@
+-- a user has at most one export in progress, keep the newest when there are several
+UPDATE swordfish.data_exports AS e
+SET status = 'failed', error = 'export was interrupted', completed_at = NOW()
+WHERE e.status = 'pending' AND EXISTS (
+	SELECT 1 FROM swordfish.data_exports AS newer
+	WHERE newer.user_id = e.user_id AND newer.status = 'pending' AND newer.id > e.id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS data_exports_user_id_pending_idx ON swordfish.data_exports (user_id) WHERE status = 'pending';
@@ -0,0 +1,9 @@
+-- an email can only be claimed by one pending change, close expired ones and keep the newest claim
+UPDATE swordfish.email_changes AS ec
+SET used_at = NOW()
+WHERE ec.used_at IS NULL AND (ec.expires_at <= NOW() OR EXISTS (
+	SELECT 1 FROM swordfish.email_changes AS newer
+	WHERE LOWER(newer.email) = LOWER(ec.email) AND newer.used_at IS NULL AND newer.id > ec.id
+));
+
+CREATE UNIQUE INDEX IF NOT EXISTS email_changes_email_pending_idx ON swordfish.email_changes (LOWER(email)) WHERE used_at IS NULL;
@@ -4,12 +4,18 @@ import (
 	"database/sql"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/halosatrio/xwing/db"
+	"github.com/halosatrio/xwing/mailer"
 	"github.com/halosatrio/xwing/routes"
+	"github.com/halosatrio/xwing/scheduler"
+	"github.com/halosatrio/xwing/sso"
 	"github.com/halosatrio/xwing/utils"
 	"github.com/joho/godotenv"
 )
@@ -22,28 +28,69 @@ func main() {
 		log.Fatalf("Error loading .env file")
 	}
 
+	// load the JWT signing keys, without JWT_KEY_DIR tokens are signed with JWT_SECRET
+	if err := utils.LoadSigningKeys(); err != nil {
+		log.Fatalf("Error loading JWT signing keys: %v", err)
+	}
+
 	// connect DB
 	dbx := db.ConnectDB()
 	defer dbx.Close()
 
+	// start recurring transaction scheduler
+	recurringInterval := time.Hour // Default
+	if envInterval := os.Getenv("RECURRING_INTERVAL_MINUTES"); envInterval != "" {
+		if minutes, err := strconv.Atoi(envInterval); err == nil && minutes > 0 {
+			recurringInterval = time.Duration(minutes) * time.Minute
+		}
+	}
+	scheduler.StartRecurring(dbx, recurringInterval)
+
+	// start trash purge, deleted transactions are kept for TRASH_RETENTION_DAYS (0 keeps them forever)
+	trashRetentionDays := 30 // Default
+	if envDays := os.Getenv("TRASH_RETENTION_DAYS"); envDays != "" {
+		if days, err := strconv.Atoi(envDays); err == nil && days >= 0 {
+			trashRetentionDays = days
+		}
+	}
+	if trashRetentionDays > 0 {
+		scheduler.StartTrashPurge(dbx, time.Duration(trashRetentionDays)*24*time.Hour, time.Hour)
+	}
+
 	// setup routes
-	r := setupRouter(dbx)
+	r := setupRouter(dbx, mailer.FromEnv(), sso.FromEnv(routes.OIDCRedirectURL()))
 	r.Run(":8080")
 }
 
 // setup app, define routes
-func setupRouter(db *sql.DB) *gin.Engine {
+func setupRouter(db *sql.DB, mail mailer.Mailer, oidc *sso.Provider) *gin.Engine {
 
 	r := gin.Default()
 
+	// Only trust X-Forwarded-For from the proxies in TRUSTED_PROXIES (comma separated IPs or CIDRs),
+	// otherwise any client could pick the IP used by the login throttle, sessions and audit events
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
 	// Custom CORS configuration
 	corsConfig := cors.Config{
 		// List allowed origins
 		AllowOrigins: []string{"http://localhost:3000", "http://localhost:5173", "http://localhost:8080", "https://dinero.bayubit.com"},
 		// Allow specific methods
-		AllowMethods: []string{"GET", "POST", "PUT", "DELETE"},
+		AllowMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
 		// Allow specific headers
-		AllowHeaders: []string{"Origin", "Content-Type", "Authorization"},
+		AllowHeaders: []string{"Origin", "Content-Type", "Authorization", "If-Match", routes.LedgerHeader, utils.IdempotencyKeyHeader},
+		// Let clients read the version of a resource for If-Match and see replayed responses
+		ExposeHeaders: []string{"ETag", "Idempotent-Replayed"},
+		// Send the OIDC login cookie, it binds a login to the browser that started it
+		AllowCredentials: true,
 		// Cache the preflight response for 12 hours
 		MaxAge: 12 * time.Hour,
 	}
@@ -51,6 +98,9 @@ func setupRouter(db *sql.DB) *gin.Engine {
 	// Apply the custom CORS configuration
 	r.Use(cors.New(corsConfig))
 
+	// public keys to verify the issued tokens
+	r.GET("/.well-known/jwks.json", routes.GetJWKS())
+
 	// AS BASEPATH
 	v1 := r.Group("/v1")
 	{
@@ -63,34 +113,108 @@ func setupRouter(db *sql.DB) *gin.Engine {
 		})
 
 		// Register and Login [PUBLIC ROUTES]
-		v1.POST("/auth/register", routes.RegisterRoute(db))
+		v1.POST("/auth/register", routes.RegisterRoute(db, mail))
 		v1.POST("/auth/login", routes.LoginUser(db))
+		v1.POST("/auth/login/mfa", routes.LoginMFA(db))
+		v1.POST("/auth/refresh", routes.RefreshToken(db))
+		v1.GET("/auth/oidc/login", routes.GetOIDCLogin(db, oidc))
+		v1.POST("/auth/oidc/callback", routes.PostOIDCCallback(db, oidc))
+		v1.POST("/auth/password/forgot", routes.PostForgotPassword(db, mail))
+		v1.POST("/auth/password/reset", routes.PostResetPassword(db))
+		v1.POST("/auth/user/email/confirm", routes.PostConfirmEmailChange(db))
+		v1.POST("/auth/verify", routes.PostVerifyEmail(db))
+		v1.POST("/auth/verify/resend", routes.PostResendVerification(db, mail))
+		v1.GET("/auth/user/export/:id/download", routes.GetDownloadUserExport(db))
 
 		// [PRIVATE ROUTES]
-		v1.Use(utils.JWTAuth())
+		v1.Use(utils.JWTAuth(db))
 
 		// auth user
-		v1.GET("/auth/user", routes.GetUser())
+		v1.GET("/auth/user", routes.GetUser(db))
+
+		// account management is only reachable from a login session, not with an API key
+		session := v1.Group("", utils.RequireSession())
+		session.PUT("/auth/user", routes.PutUpdateUser(db, mail))
+		session.DELETE("/auth/user", routes.DeleteUser(db))
+		session.POST("/auth/user/export", routes.PostCreateUserExport(db))
+		session.GET("/auth/user/export/:id", routes.GetUserExport(db))
+		session.POST("/auth/logout", routes.LogoutUser(db))
+		session.GET("/auth/login-attempts", routes.GetLoginAttempts(db))
+		session.PUT("/auth/password", routes.PutChangePassword(db))
+		session.POST("/auth/mfa/setup", routes.PostSetupMFA(db))
+		session.POST("/auth/mfa/confirm", routes.PostConfirmMFA(db))
+		session.POST("/auth/mfa/recovery-codes", routes.PostRegenerateRecoveryCodes(db))
+		session.POST("/auth/mfa/disable", routes.PostDisableMFA(db))
+		session.GET("/auth/api-keys", routes.GetApiKeys(db))
+		session.POST("/auth/api-keys/create", routes.PostCreateApiKey(db))
+		session.DELETE("/auth/api-keys/:id", routes.DeleteApiKey(db))
+
+		// Ledger Routes
+		v1.GET("/ledger", routes.GetLedgers(db))
+		v1.GET("/ledger/:id", routes.GetLedgerById(db))
+		session.POST("/ledger/create", routes.PostCreateLedger(db))
+		session.PUT("/ledger/:id", routes.PutUpdateLedger(db))
+		session.PUT("/ledger/:id/members/:user_id", routes.PutUpdateLedgerMember(db))
+		session.DELETE("/ledger/:id/members/:user_id", routes.DeleteLedgerMember(db))
+		session.POST("/ledger/:id/invitations", routes.PostCreateLedgerInvitation(db, mail))
+		session.POST("/ledger-invitations/accept", routes.PostAcceptLedgerInvitation(db))
+
+		// every resource route below declares the scope it needs, login sessions carry all scopes
+
+		// Category Group Routes
+		v1.GET("/category-groups", utils.RequireScope(utils.ScopeReportsRead), routes.GetCategoryGroups(db))
+		v1.GET("/category-groups/:id", utils.RequireScope(utils.ScopeReportsRead), routes.GetCategoryGroupById(db))
+		v1.POST("/category-groups/create", utils.RequireScope(utils.ScopeBudgetsWrite), routes.PostCreateCategoryGroup(db))
+		v1.PUT("/category-groups/:id", utils.RequireScope(utils.ScopeBudgetsWrite), routes.PutUpdateCategoryGroup(db))
+		v1.DELETE("/category-groups/:id", utils.RequireScope(utils.ScopeBudgetsWrite), routes.DeleteCategoryGroup(db))
+
+		// Audit Log Routes, across every ledger of the user
+		v1.GET("/audit-log", utils.RequireScope(utils.ScopeTransactionsRead), routes.GetAuditLog(db))
+
+		// transactions, budgets, reports and assets belong to the ledger selected by the X-Ledger-ID header
+		ledger := v1.Group("", routes.LedgerAccess(db))
 
 		// Transaction Routes
-		v1.GET("/transaction", routes.GetAllTransactions(db))
-		v1.GET("/transaction/:id", routes.GetTransactionById(db))
-		v1.POST("/transaction/create", routes.PostCreateTransaction(db))
-		v1.PUT("/transaction/:id", routes.PutUpdateTransaction(db))
-		v1.DELETE("/transaction/:id", routes.DeleteTransaction(db))
-		v1.GET("/transaction/monthly-summary", routes.GetMonthlySummary(db))
+		ledger.GET("/transaction", utils.RequireScope(utils.ScopeTransactionsRead), routes.GetAllTransactions(db))
+		ledger.GET("/transaction/:id", utils.RequireScope(utils.ScopeTransactionsRead), routes.GetTransactionById(db))
+		ledger.GET("/transaction/trash", utils.RequireScope(utils.ScopeTransactionsRead), routes.GetTrashTransactions(db))
+		ledger.DELETE("/transaction/trash", utils.RequireScope(utils.ScopeTransactionsWrite), routes.DeleteEmptyTrash(db))
+		ledger.DELETE("/transaction/trash/:id", utils.RequireScope(utils.ScopeTransactionsWrite), routes.DeletePurgeTransaction(db))
+		ledger.POST("/transaction/:id/restore", utils.RequireScope(utils.ScopeTransactionsWrite), routes.PostRestoreTransaction(db))
+		ledger.GET("/transaction/:id/history", utils.RequireScope(utils.ScopeTransactionsRead), routes.GetTransactionHistory(db))
+		ledger.POST("/transaction/create", utils.RequireScope(utils.ScopeTransactionsWrite), utils.Idempotency(db), routes.PostCreateTransaction(db))
+		ledger.POST("/transaction/import", utils.RequireScope(utils.ScopeTransactionsWrite), routes.PostImportTransactions(db))
+		ledger.POST("/transaction/bulk", utils.RequireScope(utils.ScopeTransactionsWrite), routes.PostBulkTransactions(db))
+		ledger.PUT("/transaction/:id", utils.RequireScope(utils.ScopeTransactionsWrite), routes.PutUpdateTransaction(db))
+		ledger.PATCH("/transaction/:id", utils.RequireScope(utils.ScopeTransactionsWrite), routes.PatchTransaction(db))
+		ledger.DELETE("/transaction/:id", utils.RequireScope(utils.ScopeTransactionsWrite), routes.DeleteTransaction(db))
+		ledger.GET("/transaction/monthly-summary", utils.RequireScope(utils.ScopeTransactionsRead), routes.GetMonthlySummary(db))
 
 		// Report Routes
-		v1.GET("/report/quarter/essentials", routes.GetQuarterEssentials(db))
-		v1.GET("/report/quarter/non-essentials", routes.GetQuarterNonEssentials(db))
-		v1.GET("/report/quarter/shopping", routes.GetQuarterShopping(db))
-		v1.GET("/report/annual/cashflow", routes.GetAnnualCashflow(db))
-		// GET Annual (WIP, this is for all months per caetgory)
-		//.GET("/report/annual", routes.GetAnnualReport(db))
+		ledger.GET("/report/quarter/:group", utils.RequireScope(utils.ScopeReportsRead), routes.GetQuarterGroup(db))
+		ledger.GET("/report/annual/cashflow", utils.RequireScope(utils.ScopeReportsRead), routes.GetAnnualCashflow(db))
+		ledger.GET("/report/annual", utils.RequireScope(utils.ScopeReportsRead), routes.GetAnnualReport(db))
+		ledger.GET("/report/budget", utils.RequireScope(utils.ScopeReportsRead), routes.GetBudgetReport(db))
+
+		// Budget Routes
+		ledger.GET("/budget", utils.RequireScope(utils.ScopeReportsRead), routes.GetBudgets(db))
+		ledger.GET("/budget/:id", utils.RequireScope(utils.ScopeReportsRead), routes.GetBudgetById(db))
+		ledger.POST("/budget/create", utils.RequireScope(utils.ScopeBudgetsWrite), routes.PostCreateBudget(db))
+		ledger.PUT("/budget/:id", utils.RequireScope(utils.ScopeBudgetsWrite), routes.PutUpdateBudget(db))
+		ledger.DELETE("/budget/:id", utils.RequireScope(utils.ScopeBudgetsWrite), routes.DeleteBudget(db))
+
+		// Recurring Transaction Routes
+		ledger.GET("/recurring", utils.RequireScope(utils.ScopeTransactionsRead), routes.GetRecurringRules(db))
+		ledger.GET("/recurring/:id", utils.RequireScope(utils.ScopeTransactionsRead), routes.GetRecurringRuleById(db))
+		ledger.POST("/recurring/create", utils.RequireScope(utils.ScopeTransactionsWrite), routes.PostCreateRecurringRule(db))
+		ledger.PUT("/recurring/:id", utils.RequireScope(utils.ScopeTransactionsWrite), routes.PutUpdateRecurringRule(db))
+		ledger.DELETE("/recurring/:id", utils.RequireScope(utils.ScopeTransactionsWrite), routes.DeleteRecurringRule(db))
 
 		// Asset Routes
-		v1.GET("/asset", routes.GetAsset(db))
-		v1.POST("/asset/create", routes.PostCreateAsset(db))
+		ledger.GET("/asset", utils.RequireScope(utils.ScopeAssetsRead), routes.GetAsset(db))
+		ledger.POST("/asset/create", utils.RequireScope(utils.ScopeAssetsWrite), utils.Idempotency(db), routes.PostCreateAsset(db))
+		ledger.GET("/asset/:id", utils.RequireScope(utils.ScopeAssetsRead), routes.GetAssetById(db))
+		ledger.PATCH("/asset/:id", utils.RequireScope(utils.ScopeAssetsWrite), routes.PatchAsset(db))
 
 	}
 	return r
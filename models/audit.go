@@ -0,0 +1,19 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type AuditEventSchema struct {
+	ID            int64           `json:"id"`
+	LedgerId      int             `json:"ledger_id"`
+	ActorId       int             `json:"actor_id"`
+	ActorUsername string          `json:"actor_username"`
+	Entity        string          `json:"entity"`
+	EntityId      int             `json:"entity_id"`
+	Action        string          `json:"action"`
+	Changes       json.RawMessage `json:"changes"`
+	IP            *string         `json:"ip"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import (
+	"time"
+)
+
+type BudgetSchema struct {
+	ID        int       `json:"id"`
+	UserId    int       `json:"user_id"`
+	Category  string    `json:"category"`
+	Month     string    `json:"month"`
+	Amount    int       `json:"amount"`
+	Rollover  bool      `json:"rollover"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
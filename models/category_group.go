@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+type CategoryGroupSchema struct {
+	ID         int       `json:"id"`
+	UserId     int       `json:"user_id"`
+	Name       string    `json:"name"`
+	Categories []string  `json:"categories"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+type LedgerSchema struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	IsPersonal bool      `json:"is_personal"`
+	CreatedBy  int       `json:"created_by"`
+	Role       string    `json:"role"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type LedgerMemberSchema struct {
+	UserId    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type LedgerInvitationSchema struct {
+	ID         int        `json:"id"`
+	LedgerId   int        `json:"ledger_id"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	InvitedBy  int        `json:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+type RecurringRuleSchema struct {
+	ID          int        `json:"id"`
+	UserId      int        `json:"user_id"`
+	Type        string     `json:"type"`
+	Amount      string     `json:"amount"`
+	Category    string     `json:"category"`
+	Notes       string     `json:"notes"`
+	Frequency   string     `json:"frequency"`
+	Interval    int        `json:"interval"`
+	StartDate   time.Time  `json:"start_date"`
+	EndDate     *time.Time `json:"end_date"`
+	Count       *int       `json:"count"`
+	Occurrences int        `json:"occurrences"`
+	NextDate    *time.Time `json:"next_date"`
+	IsActive    bool       `json:"is_active"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
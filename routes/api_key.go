@@ -0,0 +1,198 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+	"github.com/lib/pq"
+)
+
+type apiKeyReq struct {
+	Name      string   `json:"name" binding:"required,max=64"`
+	ReadOnly  bool     `json:"read_only"`
+	Scopes    []string `json:"scopes" binding:"omitempty,dive,oneof=transactions:read transactions:write reports:read budgets:write assets:read assets:write"`
+	ExpiresAt string   `json:"expires_at" binding:"omitempty,datetime=2006-01-02"`
+}
+
+// scopes returns the requested scopes, every scope by default, without the write scopes for a read-only key
+func (r apiKeyReq) scopes() []string {
+	requested := r.Scopes
+	if len(requested) == 0 {
+		requested = utils.AllScopes
+	}
+	scopes := []string{}
+	for _, scope := range requested {
+		if slices.Contains(scopes, scope) || (r.ReadOnly && !slices.Contains(utils.ReadScopes, scope)) {
+			continue
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, read_only, scopes, expires_at, last_used_at, revoked_at, created_at`
+
+func scanApiKey(row interface{ Scan(...any) error }, key *models.ApiKeySchema) error {
+	return row.Scan(
+		&key.ID,
+		&key.UserId,
+		&key.Name,
+		&key.Prefix,
+		&key.ReadOnly,
+		pq.Array(&key.Scopes),
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+		&key.CreatedAt,
+	)
+}
+
+// GetApiKeys lists the API keys of the user, the key itself is never returned again
+func GetApiKeys(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keys := []models.ApiKeySchema{}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		query := `
+			SELECT ` + apiKeyColumns + `
+			FROM swordfish.api_keys
+			WHERE user_id = $1
+			ORDER BY created_at DESC
+		`
+		rows, err := db.Query(query, userID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch API keys!", err.Error())
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var key models.ApiKeySchema
+			if err := scanApiKey(rows, &key); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse API key data!", err.Error())
+				return
+			}
+			keys = append(keys, key)
+		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over API keys!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    keys,
+		})
+	}
+}
+
+// PostCreateApiKey creates an API key and returns it once, only its hash is stored
+func PostCreateApiKey(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var keyReq apiKeyReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&keyReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to create API key!", err.Error())
+			return
+		}
+
+		scopes := keyReq.scopes()
+		if len(scopes) == 0 {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to create API key!", "a read-only key needs at least one read scope")
+			return
+		}
+
+		var expiresAt *time.Time
+		if keyReq.ExpiresAt != "" {
+			parsed, err := time.Parse("2006-01-02", keyReq.ExpiresAt)
+			if err != nil {
+				utils.RespondError(c, http.StatusBadRequest, "Failed to create API key!", err.Error())
+				return
+			}
+			if !parsed.After(time.Now()) {
+				utils.RespondError(c, http.StatusBadRequest, "Failed to create API key!", "expires_at must be in the future")
+				return
+			}
+			expiresAt = &parsed
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		secret, err := utils.RandomToken(32)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to generate API key", err.Error())
+			return
+		}
+		key := utils.ApiKeyPrefix + secret
+
+		query := `
+			INSERT INTO swordfish.api_keys (user_id, name, prefix, key_hash, read_only, scopes, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING ` + apiKeyColumns
+
+		var newKey models.ApiKeySchema
+		err = scanApiKey(
+			db.QueryRow(query, userID, keyReq.Name, key[:len(utils.ApiKeyPrefix)+6], utils.HashToken(key), keyReq.ReadOnly, pq.Array(scopes), expiresAt, time.Now()),
+			&newKey,
+		)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{
+			"status":  http.StatusCreated,
+			"message": "Success create API key! Store the key now, it will not be shown again",
+			"data":    newKey,
+			"key":     key,
+		})
+	}
+}
+
+// DeleteApiKey revokes an API key, the row is kept so it stays visible in the list
+func DeleteApiKey(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		result, err := db.Exec(`
+			UPDATE swordfish.api_keys
+			SET revoked_at = $1
+			WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+		`, time.Now(), id, userID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error checking update result", err.Error())
+			return
+		}
+		if rowsAffected == 0 {
+			utils.RespondError(c, http.StatusNotFound, "API key not found or already revoked", "")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "API key revoked successfully",
+		})
+	}
+}
@@ -3,28 +3,45 @@ package routes
 import (
 	"database/sql"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/halosatrio/xwing/models"
 	"github.com/halosatrio/xwing/utils"
 )
 
+const assetColumns = `id, user_id, account, amount, date, COALESCE(notes, '') AS notes, created_at, updated_at`
+
+func scanAsset(row interface{ Scan(...any) error }, asset *models.AssetSchema) error {
+	return row.Scan(
+		&asset.ID,
+		&asset.UserId,
+		&asset.Account,
+		&asset.Amount,
+		&asset.Date,
+		&asset.Notes,
+		&asset.CreatedAt,
+		&asset.UpdatedAt,
+	)
+}
+
 func GetAsset(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var assets []models.AssetSchema
 
-		// get userid jwt
-		userID, _ := c.MustGet("user_id").(float64)
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
 
 		query := `
-			SELECT id, user_id, account, amount, date, COALESCE(notes, '') as notes, created_at, updated_at
+			SELECT ` + assetColumns + `
 			FROM swordfish.assets
-			WHERE user_id = $1
+			WHERE ledger_id = $1
 			LIMIT 200
 		`
 
-		rows, err := db.Query(query, userID)
+		rows, err := db.Query(query, ledgerID)
 		if err != nil {
 			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch transactions!", err.Error())
 			return
@@ -33,17 +50,7 @@ func GetAsset(db *sql.DB) gin.HandlerFunc {
 
 		for rows.Next() {
 			var asset models.AssetSchema
-			err := rows.Scan(
-				&asset.ID,
-				&asset.UserId,
-				&asset.Account,
-				&asset.Amount,
-				&asset.Date,
-				&asset.Notes,
-				&asset.CreatedAt,
-				&asset.UpdatedAt,
-			)
-			if err != nil {
+			if err := scanAsset(rows, &asset); err != nil {
 				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse transaction data!", err.Error())
 				return
 			}
@@ -60,10 +67,45 @@ func GetAsset(db *sql.DB) gin.HandlerFunc {
 }
 
 type createAssetReq struct {
-	Account string `form:"account" binding:"required"`
-	Amount  int    `form:"amount" binding:"required"`
-	Date    string `form:"date" binding:"required"`
-	Notes   string `form:"notes"`
+	Account string `form:"account" json:"account" binding:"required"`
+	Amount  int    `form:"amount" json:"amount" binding:"required"`
+	Date    string `form:"date" json:"date" binding:"required"`
+	Notes   string `form:"notes" json:"notes"`
+}
+
+// GetAssetById returns one asset, with its version in the ETag header
+func GetAssetById(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		var asset models.AssetSchema
+		query := `
+			SELECT ` + assetColumns + `
+			FROM swordfish.assets
+			WHERE id = $1 AND ledger_id = $2
+		`
+		err := scanAsset(db.QueryRow(query, id, ledgerID), &asset)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Asset not found", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.Header("ETag", entityTag(asset.UpdatedAt))
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    asset,
+		})
+	}
 }
 
 func PostCreateAsset(db *sql.DB) gin.HandlerFunc {
@@ -78,14 +120,16 @@ func PostCreateAsset(db *sql.DB) gin.HandlerFunc {
 
 		// get userid jwt
 		userID, _ := c.MustGet("user_id").(float64)
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
 		query := `
-			INSERT INTO swordfish.assets (user_id, account, amount, date, notes, created_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			INSERT INTO swordfish.assets (ledger_id, user_id, account, amount, date, notes, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 			RETURNING id, user_id, account, amount, date, notes, created_at, updated_at
 		`
 
 		var newAsset models.AssetSchema
-		err := db.QueryRow(query, userID, assetReq.Account, assetReq.Amount, assetReq.Date, assetReq.Notes, time.Now(), time.Now()).
+		err := db.QueryRow(query, ledgerID, userID, assetReq.Account, assetReq.Amount, assetReq.Date, assetReq.Notes, time.Now(), time.Now()).
 			Scan(&newAsset.ID, &newAsset.UserId, &newAsset.Account, &newAsset.Amount, &newAsset.Date, &newAsset.Notes, &newAsset.CreatedAt, &newAsset.UpdatedAt)
 		if err != nil {
 			utils.RespondError(c, http.StatusInternalServerError, "Failed to insert asset into database!", err.Error())
@@ -100,3 +144,93 @@ func PostCreateAsset(db *sql.DB) gin.HandlerFunc {
 		})
 	}
 }
+
+// PatchAsset applies a JSON Merge Patch to an asset, only the members present in the body change.
+// With If-Match the patch is only applied when the asset still has that ETag, otherwise it responds 412.
+func PatchAsset(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		patch, err := c.GetRawData()
+		if err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update asset!", err.Error())
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		var currentAsset models.AssetSchema
+		query := `
+			SELECT ` + assetColumns + `
+			FROM swordfish.assets
+			WHERE id = $1 AND ledger_id = $2
+			FOR UPDATE
+		`
+		err = scanAsset(tx.QueryRow(query, id, ledgerID), &currentAsset)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Asset not found", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if !checkIfMatch(c, currentAsset.UpdatedAt) {
+			return
+		}
+
+		// Validate the patched asset
+		amount, err := strconv.Atoi(currentAsset.Amount)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to parse asset data!", err.Error())
+			return
+		}
+		assetReq := createAssetReq{
+			Account: currentAsset.Account,
+			Amount:  amount,
+			Date:    currentAsset.Date.Format("2006-01-02"),
+			Notes:   currentAsset.Notes,
+		}
+		if err := mergePatch(&assetReq, patch); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update asset!", err.Error())
+			return
+		}
+		if err := binding.Validator.ValidateStruct(&assetReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update asset!", err.Error())
+			return
+		}
+
+		var updatedAsset models.AssetSchema
+		query = `
+			UPDATE swordfish.assets
+			SET account = $1, amount = $2, date = $3, notes = $4, updated_at = $5
+			WHERE id = $6
+			RETURNING ` + assetColumns
+		err = scanAsset(tx.QueryRow(query, assetReq.Account, assetReq.Amount, assetReq.Date, assetReq.Notes, time.Now(), id), &updatedAsset)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to update asset!", err.Error())
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.Header("ETag", entityTag(updatedAsset.UpdatedAt))
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success update asset!",
+			"data":    updatedAsset,
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/audit"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+)
+
+const auditEventColumns = `e.id, e.ledger_id, e.actor_id, u.username, e.entity, e.entity_id, e.action, e.changes, e.ip, e.created_at`
+
+func scanAuditEvent(row interface{ Scan(...any) error }, event *models.AuditEventSchema) error {
+	return row.Scan(
+		&event.ID,
+		&event.LedgerId,
+		&event.ActorId,
+		&event.ActorUsername,
+		&event.Entity,
+		&event.EntityId,
+		&event.Action,
+		&event.Changes,
+		&event.IP,
+		&event.CreatedAt,
+	)
+}
+
+// recordTransactionEvent adds a transaction change made by the request to the audit trail,
+// before is nil for a create
+func recordTransactionEvent(ex execer, c *gin.Context, ledgerID int, action string, before, after *models.TransactionSchema) error {
+	// get userid jwt
+	userID, _ := c.MustGet("user_id").(float64)
+
+	return audit.Record(ex, audit.Event{
+		LedgerID: ledgerID,
+		ActorID:  int(userID),
+		Entity:   audit.EntityTransaction,
+		EntityID: after.ID,
+		Action:   action,
+		Before:   before,
+		After:    after,
+		IP:       c.ClientIP(),
+	})
+}
+
+// queryAuditEvents runs an audit event query and collects the rows
+func queryAuditEvents(db *sql.DB, query string, args ...any) ([]models.AuditEventSchema, error) {
+	events := []models.AuditEventSchema{}
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event models.AuditEventSchema
+		if err := scanAuditEvent(rows, &event); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// GetTransactionHistory lists every change of a transaction in the ledger, oldest first.
+// The history stays available after the transaction is purged from the trash.
+func GetTransactionHistory(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		events, err := queryAuditEvents(db, `
+			SELECT `+auditEventColumns+`
+			FROM swordfish.audit_events AS e
+			JOIN swordfish.users AS u ON u.id = e.actor_id
+			WHERE e.ledger_id = $1 AND e.entity = $2 AND e.entity_id = $3
+			ORDER BY e.id ASC
+		`, ledgerID, audit.EntityTransaction, id)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch transaction history!", err.Error())
+			return
+		}
+		if len(events) == 0 {
+			utils.RespondError(c, http.StatusNotFound, "Transaction not found", "")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    events,
+		})
+	}
+}
+
+type auditLogQueryReq struct {
+	LedgerID int    `form:"ledger_id" binding:"omitempty,min=1"`
+	Entity   string `form:"entity" binding:"omitempty,oneof=transaction"`
+	Action   string `form:"action" binding:"omitempty,oneof=create update delete restore"`
+	ActorID  int    `form:"actor_id" binding:"omitempty,min=1"`
+	Limit    int    `form:"limit" binding:"omitempty,min=1"`
+	Cursor   string `form:"cursor"`
+}
+
+// GetAuditLog lists the audit events of every ledger the user is a member of, newest first
+func GetAuditLog(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var queryReq auditLogQueryReq
+
+		// Bind query parameters
+		if err := c.BindQuery(&queryReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid query parameters!", err.Error())
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		limit := queryReq.Limit
+		if limit == 0 {
+			limit = defaultPageLimit
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+
+		qb := &queryBuilder{}
+		qb.where("e.ledger_id IN (SELECT ledger_id FROM swordfish.ledger_members WHERE user_id = ?)", userID)
+		if queryReq.LedgerID != 0 {
+			qb.where("e.ledger_id = ?", queryReq.LedgerID)
+		}
+		if queryReq.Entity != "" {
+			qb.where("e.entity = ?", queryReq.Entity)
+		}
+		if queryReq.Action != "" {
+			qb.where("e.action = ?", queryReq.Action)
+		}
+		if queryReq.ActorID != 0 {
+			qb.where("e.actor_id = ?", queryReq.ActorID)
+		}
+		if queryReq.Cursor != "" {
+			cursor, err := decodeCursor(queryReq.Cursor)
+			if err != nil || cursor.Sort != "id" {
+				utils.RespondError(c, http.StatusBadRequest, "Invalid cursor!", "cursor does not belong to the audit log")
+				return
+			}
+			qb.where("e.id < ?", cursor.ID)
+		}
+
+		// fetch one extra row to know whether there is a next page
+		query := `
+			SELECT ` + auditEventColumns + `
+			FROM swordfish.audit_events AS e
+			JOIN swordfish.users AS u ON u.id = e.actor_id
+			WHERE ` + qb.sql() + fmt.Sprintf(" ORDER BY e.id DESC LIMIT %d", limit+1)
+		events, err := queryAuditEvents(db, query, qb.params()...)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch audit log!", err.Error())
+			return
+		}
+
+		var nextCursor *string
+		if len(events) > limit {
+			events = events[:limit]
+			last := events[limit-1]
+			token := encodeCursor(pageCursor{
+				Sort:  "id",
+				Order: "desc",
+				Value: strconv.FormatInt(last.ID, 10),
+				ID:    int(last.ID),
+			})
+			nextCursor = &token
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    events,
+			"pagination": gin.H{
+				"limit":       limit,
+				"next_cursor": nextCursor,
+			},
+		})
+	}
+}
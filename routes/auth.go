@@ -2,14 +2,14 @@ package routes
 
 import (
 	"database/sql"
+	"log"
 	"net/http"
-	"os"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/halosatrio/xwing/mailer"
 	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -20,8 +20,17 @@ type registerUserReq struct {
 	Password string `json:"password" binding:"required,min=8"`
 }
 
-// RegisterRoute handles user registration
-func RegisterRoute(db *sql.DB) gin.HandlerFunc {
+// setupNewUser creates what every new account starts with, a personal ledger and
+// the default category groups. Both password and identity provider registration use it.
+func setupNewUser(tx *sql.Tx, userID int) error {
+	if err := createPersonalLedger(tx, userID); err != nil {
+		return err
+	}
+	return createDefaultCategoryGroups(tx, userID)
+}
+
+// RegisterRoute handles user registration and sends the email verification link
+func RegisterRoute(db *sql.DB, mail mailer.Mailer) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var userReq registerUserReq
 		var existingUser models.UserSchema
@@ -73,6 +82,13 @@ func RegisterRoute(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
 		// Insert user into the database
 		query := `
 			INSERT INTO swordfish.users (username, email, password, created_at)
@@ -81,7 +97,7 @@ func RegisterRoute(db *sql.DB) gin.HandlerFunc {
 		`
 
 		var newUser models.UserSchema
-		err = db.QueryRow(query, userReq.Username, userReq.Email, string(hashedPassword), time.Now()).
+		err = tx.QueryRow(query, userReq.Username, userReq.Email, string(hashedPassword), time.Now()).
 			Scan(&newUser.ID, &newUser.Username, &newUser.Email)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
@@ -92,10 +108,25 @@ func RegisterRoute(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		if err := setupNewUser(tx, newUser.ID); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to set up user!", err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		// a failed email does not undo the registration, the link can be resent
+		if err := sendVerificationEmail(mail, newUser.ID, newUser.Email); err != nil {
+			log.Printf("[auth][register] failed to send verification email: %v", err)
+		}
+
 		// Respond with success
 		c.JSON(http.StatusOK, gin.H{
 			"status":  http.StatusOK,
-			"message": "User registered successfully!",
+			"message": "User registered successfully! Check your email to verify it",
 		})
 	}
 }
@@ -120,21 +151,23 @@ func LoginUser(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		// Refuse while the email or ip is backing off after failed attempts,
+		// the attempt counts as failed until the password is known to be right
+		attemptID, ok := reserveLoginAttempt(c, db, loginReq.Email)
+		if !ok {
+			return
+		}
+
 		// Query user by email
 		queryGetUserByEmail := `
-			SELECT id, username, email, password
+			SELECT id, username, email, password, email_verified_at IS NOT NULL
 			FROM swordfish.users
 			WHERE email=$1
 		`
+		var emailVerified bool
 		err := db.QueryRow(queryGetUserByEmail, loginReq.Email).
-			Scan(&user.ID, &user.Username, &user.Email, &user.Password)
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Invalid email or password",
-			})
-			return
-		} else if err != nil {
+			Scan(&user.ID, &user.Username, &user.Email, &user.Password, &emailVerified)
+		if err != nil && err != sql.ErrNoRows {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  http.StatusInternalServerError,
 				"message": "Database error",
@@ -143,44 +176,70 @@ func LoginUser(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// Compare password
-		err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(loginReq.Password))
-		if err != nil {
+		// Compare password, unknown emails are compared against a dummy hash
+		// so both cases take the same time and get the same response
+		passwordHash := []byte(user.Password)
+		if err == sql.ErrNoRows {
+			passwordHash = dummyPasswordHash
+		}
+		if bcrypt.CompareHashAndPassword(passwordHash, []byte(loginReq.Password)) != nil || err == sql.ErrNoRows {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"status":  http.StatusUnauthorized,
-				"message": "invalid credentials",
-				"error":   err.Error(),
+				"message": "Invalid email or password",
 			})
 			return
 		}
 
-		// Generate JWT
-		secret := os.Getenv("JWT_SECRET")
-		if secret == "" {
+		// Unverified accounts are refused when the configuration requires verification
+		if emailVerificationRequired() && !emailVerified {
+			if err := releaseLoginAttempt(db, attemptID); err != nil {
+				log.Printf("[auth][login] failed to release login attempt: %v", err)
+			}
+			utils.RespondError(c, http.StatusForbidden, "Email is not verified", "open the verification link sent to your email or request a new one")
+			return
+		}
+
+		// With two-factor authentication the password only unlocks the second step
+		mfaEnabled, err := isMFAEnabled(db, user.ID)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  http.StatusInternalServerError,
-				"message": "Server misconfiguration",
-				"error":   "JWT secret is not set",
+				"message": "Database error",
+				"error":   err.Error(),
 			})
 			return
 		}
-
-		// Set token expiration
-		tokenDuration := time.Hour * 24 // Default
-		if envDuration := os.Getenv("JWT_EXPIRATION_HOURS"); envDuration != "" {
-			if hours, err := strconv.Atoi(envDuration); err == nil {
-				tokenDuration = time.Duration(hours) * time.Hour
+		if mfaEnabled {
+			// the code step records the outcome of the login
+			if err := releaseLoginAttempt(db, attemptID); err != nil {
+				log.Printf("[auth][login] failed to release login attempt: %v", err)
+			}
+			mfaToken, err := utils.GenerateMFAToken(user.ID)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"status":  http.StatusInternalServerError,
+					"message": "Failed to generate token",
+					"error":   "[auth][jwt]" + err.Error(),
+				})
+				return
 			}
+			c.JSON(http.StatusOK, gin.H{
+				"status":  http.StatusOK,
+				"message": "Two-factor authentication code required",
+				"data": gin.H{
+					"mfa_required": true,
+					"mfa_token":    mfaToken,
+				},
+			})
+			return
 		}
 
-		// Create token
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-			"sub":   user.ID,
-			"email": user.Email,
-			"exp":   time.Now().Add(tokenDuration).Unix(),
-		})
+		if err := completeLoginAttempt(db, attemptID); err != nil {
+			log.Printf("[auth][login] failed to record login attempt: %v", err)
+		}
 
-		tokenString, err := token.SignedString([]byte(secret))
+		// Start a session, the access token is short-lived and renewed with the refresh token
+		tokens, err := issueTokens(db, c, user.ID, user.Email)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  http.StatusInternalServerError,
@@ -191,27 +250,6 @@ func LoginUser(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// Respond with success
-		c.JSON(http.StatusOK, gin.H{
-			"status":  http.StatusOK,
-			"message": "Success Login!",
-			"data":    tokenString,
-		})
-	}
-}
-
-func GetUser() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		userID, _ := c.MustGet("user_id").(float64)
-		email, _ := c.MustGet("email").(string)
-
-		// Respond with success
-		c.JSON(http.StatusOK, gin.H{
-			"status":  http.StatusOK,
-			"message": "Success Login!",
-			"data": gin.H{
-				"user_id": userID,
-				"email":   email,
-			},
-		})
+		respondTokens(c, "Success Login!", tokens)
 	}
 }
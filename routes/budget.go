@@ -0,0 +1,382 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+)
+
+type budgetReq struct {
+	Category string `json:"category" binding:"required"`
+	Month    string `json:"month" binding:"required,datetime=2006-01"`
+	Amount   int    `json:"amount" binding:"required,min=1"`
+	Rollover bool   `json:"rollover"`
+}
+
+type budgetQueryReq struct {
+	Month string `form:"month" binding:"omitempty,datetime=2006-01"`
+}
+
+// monthStart converts a YYYY-MM month into its first date
+func monthStart(month string) (time.Time, error) {
+	return time.Parse("2006-01", month)
+}
+
+const budgetColumns = `id, user_id, category, to_char(month, 'YYYY-MM') as month, amount, rollover, created_at, updated_at`
+
+func scanBudget(row interface{ Scan(...any) error }, budget *models.BudgetSchema) error {
+	return row.Scan(
+		&budget.ID,
+		&budget.UserId,
+		&budget.Category,
+		&budget.Month,
+		&budget.Amount,
+		&budget.Rollover,
+		&budget.CreatedAt,
+		&budget.UpdatedAt,
+	)
+}
+
+func GetBudgets(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var queryReq budgetQueryReq
+		budgets := []models.BudgetSchema{}
+
+		// Bind query parameters
+		if err := c.BindQuery(&queryReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid query parameters!", err.Error())
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		qb := &queryBuilder{}
+		qb.where("ledger_id = ?", ledgerID)
+		if queryReq.Month != "" {
+			month, _ := monthStart(queryReq.Month)
+			qb.where("month = ?", month)
+		}
+
+		query := `
+			SELECT ` + budgetColumns + `
+			FROM swordfish.budgets
+			WHERE ` + qb.sql() + `
+			ORDER BY month DESC, category ASC
+		`
+
+		rows, err := db.Query(query, qb.params()...)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch budgets!", err.Error())
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var budget models.BudgetSchema
+			if err := scanBudget(rows, &budget); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse budget data!", err.Error())
+				return
+			}
+			budgets = append(budgets, budget)
+		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over budgets!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    budgets,
+		})
+	}
+}
+
+func GetBudgetById(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		query := `
+			SELECT ` + budgetColumns + `
+			FROM swordfish.budgets
+			WHERE ledger_id = $1 AND id = $2
+		`
+
+		var budget models.BudgetSchema
+		err := scanBudget(db.QueryRow(query, ledgerID, id), &budget)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Budget not found", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    budget,
+		})
+	}
+}
+
+func PostCreateBudget(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var budgetReq budgetReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&budgetReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to create budget!", err.Error())
+			return
+		}
+		month, _ := monthStart(budgetReq.Month)
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		query := `
+			INSERT INTO swordfish.budgets (ledger_id, user_id, category, month, amount, rollover, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			RETURNING ` + budgetColumns
+
+		var newBudget models.BudgetSchema
+		err := scanBudget(
+			db.QueryRow(query, ledgerID, userID, budgetReq.Category, month, budgetReq.Amount, budgetReq.Rollover, time.Now(), time.Now()),
+			&newBudget,
+		)
+		if utils.IsUniqueViolation(err) {
+			utils.RespondError(c, http.StatusConflict, "Budget for this category and month already exists", err.Error())
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to insert budget into database!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success create budget!",
+			"data":    newBudget,
+		})
+	}
+}
+
+func PutUpdateBudget(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var budgetReq budgetReq
+
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&budgetReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update budget!", err.Error())
+			return
+		}
+		month, _ := monthStart(budgetReq.Month)
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		query := `
+			UPDATE swordfish.budgets
+			SET category = $1, month = $2, amount = $3, rollover = $4, updated_at = $5
+			WHERE id = $6 AND ledger_id = $7
+			RETURNING ` + budgetColumns
+
+		var updatedBudget models.BudgetSchema
+		err := scanBudget(
+			db.QueryRow(query, budgetReq.Category, month, budgetReq.Amount, budgetReq.Rollover, time.Now(), id, ledgerID),
+			&updatedBudget,
+		)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Budget not found", "")
+			return
+		} else if utils.IsUniqueViolation(err) {
+			utils.RespondError(c, http.StatusConflict, "Budget for this category and month already exists", err.Error())
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success update budget!",
+			"data":    updatedBudget,
+		})
+	}
+}
+
+func DeleteBudget(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		result, err := db.Exec(`DELETE FROM swordfish.budgets WHERE id = $1 AND ledger_id = $2`, id, ledgerID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error checking delete result", err.Error())
+			return
+		}
+		if rowsAffected == 0 {
+			utils.RespondError(c, http.StatusNotFound, "Budget not found", "")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Budget deleted successfully",
+		})
+	}
+}
+
+type budgetReportQueryReq struct {
+	Month string `form:"month" binding:"required,datetime=2006-01"`
+}
+
+type BudgetReport struct {
+	Category  string `json:"category"`
+	Planned   int    `json:"planned"`
+	Rollover  int    `json:"rollover"`
+	Actual    int    `json:"actual"`
+	Remaining int    `json:"remaining"`
+}
+
+// GetBudgetReport returns planned vs. actual outflow per budgeted category for a month.
+// Unspent amounts of rollover budgets carry into the budget of the following month,
+// as long as there is no month without a budget in between.
+func GetBudgetReport(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var queryReq budgetReportQueryReq
+
+		// Bind query parameters
+		if err := c.BindQuery(&queryReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid query parameters!", err.Error())
+			return
+		}
+		month, _ := monthStart(queryReq.Month)
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		// every budget up to the requested month of the categories budgeted in that month,
+		// joined with the actual outflow of the same category and month
+		query := `
+			SELECT
+				b.category,
+				b.month,
+				b.amount,
+				b.rollover,
+				CAST(COALESCE(SUM(tx.amount), 0) AS int) AS actual
+			FROM swordfish.budgets AS b
+			LEFT JOIN swordfish.transactions AS tx
+				ON tx.ledger_id = b.ledger_id
+				AND tx.category = b.category
+				AND tx.is_active = true
+				AND tx.type = 'outflow'
+				AND tx.date >= b.month
+				AND tx.date < b.month + INTERVAL '1 month'
+			WHERE b.ledger_id = $1
+				AND b.month <= $2
+				AND b.category IN (SELECT category FROM swordfish.budgets WHERE ledger_id = $1 AND month = $2)
+			GROUP BY b.id, b.category, b.month, b.amount, b.rollover
+			ORDER BY b.category, b.month
+		`
+
+		rows, err := db.Query(query, ledgerID, month)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch data!", err.Error())
+			return
+		}
+		defer rows.Close()
+
+		var (
+			result    = []BudgetReport{}
+			category  string
+			carried   int
+			prevMonth time.Time
+		)
+		for rows.Next() {
+			var (
+				rowCategory string
+				rowMonth    time.Time
+				planned     int
+				rollover    bool
+				actual      int
+			)
+			if err := rows.Scan(&rowCategory, &rowMonth, &planned, &rollover, &actual); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse data!", err.Error())
+				return
+			}
+
+			// a new category or a gap between budget months resets the carried amount
+			if rowCategory != category || !rowMonth.Equal(prevMonth.AddDate(0, 1, 0)) {
+				carried = 0
+			}
+
+			report := BudgetReport{
+				Category:  rowCategory,
+				Planned:   planned,
+				Rollover:  carried,
+				Actual:    actual,
+				Remaining: planned + carried - actual,
+			}
+
+			carried = 0
+			if rollover && report.Remaining > 0 {
+				carried = report.Remaining
+			}
+			if rowMonth.Equal(month) {
+				result = append(result, report)
+			}
+			category, prevMonth = rowCategory, rowMonth
+		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over data!", err.Error())
+			return
+		}
+
+		total := BudgetReport{Category: "total"}
+		for _, report := range result {
+			total.Planned += report.Planned
+			total.Rollover += report.Rollover
+			total.Actual += report.Actual
+			total.Remaining += report.Remaining
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data": gin.H{
+				"month":      queryReq.Month,
+				"categories": result,
+				"total":      total,
+			},
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+	"github.com/lib/pq"
+)
+
+// defaultCategoryGroups are the groups every new user starts with, the quarter report
+// used to have them built in
+var defaultCategoryGroups = []struct {
+	name       string
+	categories []string
+}{
+	{"essentials", []string{"makan", "cafe", "utils", "errand", "bensin", "olahraga"}},
+	{"non-essentials", []string{"misc", "family", "transport", "traveling", "healthcare", "date"}},
+	{"shopping", []string{"belanja"}},
+}
+
+// createDefaultCategoryGroups gives a new user the default category groups
+func createDefaultCategoryGroups(tx *sql.Tx, userID int) error {
+	for _, group := range defaultCategoryGroups {
+		_, err := tx.Exec(`
+			INSERT INTO swordfish.category_groups (user_id, name, categories, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $4)
+		`, userID, group.name, pq.Array(group.categories), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type categoryGroupReq struct {
+	Name       string   `json:"name" binding:"required,max=64"`
+	Categories []string `json:"categories" binding:"required,min=1,dive,required"`
+}
+
+// normalizeCategories trims and removes duplicate categories, keeping the original order
+func normalizeCategories(categories []string) []string {
+	seen := make(map[string]bool)
+	result := []string{}
+	for _, category := range categories {
+		category = strings.TrimSpace(category)
+		if category == "" || seen[category] {
+			continue
+		}
+		seen[category] = true
+		result = append(result, category)
+	}
+	return result
+}
+
+// bindCategoryGroup validates the request body and normalizes its categories,
+// a list left empty after trimming is rejected like a missing one
+func bindCategoryGroup(c *gin.Context, groupReq *categoryGroupReq, message string) bool {
+	if err := c.ShouldBindJSON(groupReq); err != nil {
+		utils.RespondError(c, http.StatusBadRequest, message, err.Error())
+		return false
+	}
+	groupReq.Name = strings.TrimSpace(groupReq.Name)
+	groupReq.Categories = normalizeCategories(groupReq.Categories)
+	if groupReq.Name == "" || len(groupReq.Categories) == 0 {
+		utils.RespondError(c, http.StatusBadRequest, message, "name and at least one category are required")
+		return false
+	}
+	return true
+}
+
+// getGroupCategories returns the categories of the user's group by name.
+// ok is false when no such group exists.
+func getGroupCategories(db *sql.DB, userID float64, name string) ([]string, bool, error) {
+	query := `
+		SELECT categories
+		FROM swordfish.category_groups
+		WHERE user_id = $1 AND LOWER(name) = LOWER($2)
+	`
+
+	var categories []string
+	err := db.QueryRow(query, userID, name).Scan(pq.Array(&categories))
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return categories, true, nil
+}
+
+func scanCategoryGroup(row interface{ Scan(...any) error }, group *models.CategoryGroupSchema) error {
+	return row.Scan(
+		&group.ID,
+		&group.UserId,
+		&group.Name,
+		pq.Array(&group.Categories),
+		&group.CreatedAt,
+		&group.UpdatedAt,
+	)
+}
+
+func GetCategoryGroups(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		groups := []models.CategoryGroupSchema{}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		query := `
+			SELECT id, user_id, name, categories, created_at, updated_at
+			FROM swordfish.category_groups
+			WHERE user_id = $1
+			ORDER BY name
+		`
+
+		rows, err := db.Query(query, userID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch category groups!", err.Error())
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var group models.CategoryGroupSchema
+			if err := scanCategoryGroup(rows, &group); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse category group data!", err.Error())
+				return
+			}
+			groups = append(groups, group)
+		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over category groups!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    groups,
+		})
+	}
+}
+
+func GetCategoryGroupById(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		query := `
+			SELECT id, user_id, name, categories, created_at, updated_at
+			FROM swordfish.category_groups
+			WHERE user_id = $1 AND id = $2
+		`
+
+		var group models.CategoryGroupSchema
+		err := scanCategoryGroup(db.QueryRow(query, userID, id), &group)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Category group not found", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    group,
+		})
+	}
+}
+
+func PostCreateCategoryGroup(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var groupReq categoryGroupReq
+
+		// Validate request body
+		if !bindCategoryGroup(c, &groupReq, "Failed to create category group!") {
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		query := `
+			INSERT INTO swordfish.category_groups (user_id, name, categories, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, user_id, name, categories, created_at, updated_at
+		`
+
+		var newGroup models.CategoryGroupSchema
+		err := scanCategoryGroup(
+			db.QueryRow(query, userID, groupReq.Name, pq.Array(groupReq.Categories), time.Now(), time.Now()),
+			&newGroup,
+		)
+		if utils.IsUniqueViolation(err) {
+			utils.RespondError(c, http.StatusConflict, "Category group already exists", err.Error())
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to insert category group into database!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success create category group!",
+			"data":    newGroup,
+		})
+	}
+}
+
+func PutUpdateCategoryGroup(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var groupReq categoryGroupReq
+
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// Validate request body
+		if !bindCategoryGroup(c, &groupReq, "Failed to update category group!") {
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		query := `
+			UPDATE swordfish.category_groups
+			SET name = $1, categories = $2, updated_at = $3
+			WHERE id = $4 AND user_id = $5
+			RETURNING id, user_id, name, categories, created_at, updated_at
+		`
+
+		var updatedGroup models.CategoryGroupSchema
+		err := scanCategoryGroup(
+			db.QueryRow(query, groupReq.Name, pq.Array(groupReq.Categories), time.Now(), id, userID),
+			&updatedGroup,
+		)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Category group not found", "")
+			return
+		} else if utils.IsUniqueViolation(err) {
+			utils.RespondError(c, http.StatusConflict, "Category group already exists", err.Error())
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success update category group!",
+			"data":    updatedGroup,
+		})
+	}
+}
+
+func DeleteCategoryGroup(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		query := `
+			DELETE FROM swordfish.category_groups
+			WHERE id = $1 AND user_id = $2
+		`
+		result, err := db.Exec(query, id, userID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error checking delete result", err.Error())
+			return
+		}
+		if rowsAffected == 0 {
+			utils.RespondError(c, http.StatusNotFound, "Category group not found", "")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Category group deleted successfully",
+		})
+	}
+}
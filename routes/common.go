@@ -0,0 +1,47 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/utils"
+)
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+type resourceID struct {
+	ID string `uri:"id" binding:"required"`
+}
+
+// bindID validates the :id uri parameter and converts it to an integer,
+// responding with 400 and returning false when it is missing or not a number.
+func bindID(c *gin.Context) (int, bool) {
+	var uri resourceID
+	if err := c.ShouldBindUri(&uri); err != nil {
+		utils.RespondError(c, http.StatusBadRequest, "Invalid URI parameter!", err.Error())
+		return 0, false
+	}
+
+	id, err := strconv.Atoi(uri.ID)
+	if err != nil {
+		utils.RespondError(c, http.StatusBadRequest, "ID must be an integer!", err.Error())
+		return 0, false
+	}
+	return id, true
+}
+
+// appLink returns a link to the frontend, APP_URL overrides the local default
+func appLink(path string) string {
+	base := os.Getenv("APP_URL")
+	if base == "" {
+		base = "http://localhost:5173"
+	}
+	return strings.TrimRight(base, "/") + path
+}
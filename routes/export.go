@@ -0,0 +1,200 @@
+package routes
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// exportReq is embedded in the query of endpoints that can be downloaded as a file,
+// an empty format keeps the regular JSON response
+type exportReq struct {
+	Format string `form:"format" binding:"omitempty,oneof=json csv xlsx"`
+}
+
+func (r exportReq) isExport() bool {
+	return r.Format == "csv" || r.Format == "xlsx"
+}
+
+// exportWriter streams one or more tables to the response,
+// as sheets of a workbook for xlsx and as consecutive sections for csv
+type exportWriter interface {
+	WriteSheet(name string, headers ...string) error
+	WriteRow(values ...interface{}) error
+	Close() error
+}
+
+// exportFilenameUnsafe matches the characters that are replaced in download filenames
+var exportFilenameUnsafe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// newExportWriter sets the download headers and returns the writer for the format
+func newExportWriter(c *gin.Context, format, filename string) exportWriter {
+	filename = exportFilenameUnsafe.ReplaceAllString(filename, "-")
+	if format == "xlsx" {
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.xlsx"`, filename))
+		return &xlsxExportWriter{c: c, file: excelize.NewFile()}
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, filename))
+	return &csvExportWriter{writer: csv.NewWriter(c.Writer)}
+}
+
+// closeExport finishes the file, or logs err when writing failed. The file is
+// already being streamed at that point, so the status code can no longer change.
+func closeExport(c *gin.Context, w exportWriter, err error) {
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Printf("[export] %v", err)
+		c.Abort()
+	}
+}
+
+// exportValue formats dates the same way in both formats
+func exportValue(value interface{}) interface{} {
+	if v, ok := value.(time.Time); ok {
+		return v.Format("2006-01-02")
+	}
+	return value
+}
+
+// csvCell formats a value for the csv file. Text starting like a formula is prefixed with '
+// so spreadsheets show notes and categories instead of evaluating them, numbers such as
+// amounts are kept as they are. xlsx cells are typed as text and need no escaping.
+func csvCell(value interface{}) string {
+	cell := fmt.Sprint(exportValue(value))
+	if _, err := strconv.ParseFloat(cell, 64); err == nil {
+		return cell
+	}
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// maxSheetNameLength is the longest sheet name Excel accepts
+const maxSheetNameLength = 31
+
+// exportSheetName replaces the characters Excel does not allow in sheet names and truncates the name
+func exportSheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:\/?*[]`, r) {
+			return '-'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, "'")
+	if runes := []rune(name); len(runes) > maxSheetNameLength {
+		name = string(runes[:maxSheetNameLength])
+	}
+	if name == "" {
+		name = "Sheet1"
+	}
+	return name
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+	sheets int
+}
+
+func (w *csvExportWriter) WriteSheet(name string, headers ...string) error {
+	w.sheets++
+	if w.sheets > 1 {
+		// separate the sections with an empty line and the section name
+		if err := w.writer.Write([]string{}); err != nil {
+			return err
+		}
+		if err := w.writer.Write([]string{name}); err != nil {
+			return err
+		}
+	}
+	return w.writer.Write(headers)
+}
+
+func (w *csvExportWriter) WriteRow(values ...interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = csvCell(value)
+	}
+	return w.writer.Write(record)
+}
+
+func (w *csvExportWriter) Close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+type xlsxExportWriter struct {
+	c      *gin.Context
+	file   *excelize.File
+	stream *excelize.StreamWriter
+	row    int
+}
+
+func (w *xlsxExportWriter) WriteSheet(name string, headers ...string) error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	name = exportSheetName(name)
+
+	// the new workbook starts with a default sheet, reuse it for the first table
+	if w.stream == nil && w.file.SheetCount == 1 {
+		if err := w.file.SetSheetName(w.file.GetSheetName(0), name); err != nil {
+			return err
+		}
+	} else if _, err := w.file.NewSheet(name); err != nil {
+		return err
+	}
+
+	stream, err := w.file.NewStreamWriter(name)
+	if err != nil {
+		return err
+	}
+	w.stream = stream
+	w.row = 0
+
+	values := make([]interface{}, len(headers))
+	for i, header := range headers {
+		values[i] = header
+	}
+	return w.WriteRow(values...)
+}
+
+func (w *xlsxExportWriter) WriteRow(values ...interface{}) error {
+	w.row++
+	cell, err := excelize.CoordinatesToCellName(1, w.row)
+	if err != nil {
+		return err
+	}
+	row := make([]interface{}, len(values))
+	for i, value := range values {
+		row[i] = exportValue(value)
+	}
+	return w.stream.SetRow(cell, row)
+}
+
+func (w *xlsxExportWriter) flush() error {
+	if w.stream == nil {
+		return nil
+	}
+	return w.stream.Flush()
+}
+
+func (w *xlsxExportWriter) Close() error {
+	defer w.file.Close()
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.file.Write(w.c.Writer)
+}
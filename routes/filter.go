@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// queryBuilder collects WHERE conditions and their arguments, numbering
+// the postgres placeholders in the order the conditions are added
+type queryBuilder struct {
+	conditions []string
+	args       []interface{}
+}
+
+// where adds a condition, every "?" in cond is replaced by the placeholder of the matching arg
+func (b *queryBuilder) where(cond string, args ...interface{}) {
+	for _, arg := range args {
+		b.args = append(b.args, arg)
+		cond = strings.Replace(cond, "?", fmt.Sprintf("$%d", len(b.args)), 1)
+	}
+	b.conditions = append(b.conditions, cond)
+}
+
+// sql returns the conditions joined with AND, without the WHERE keyword
+func (b *queryBuilder) sql() string {
+	if len(b.conditions) == 0 {
+		return "true"
+	}
+	return strings.Join(b.conditions, " AND ")
+}
+
+func (b *queryBuilder) params() []interface{} {
+	return b.args
+}
+
+// newTransactionQuery starts a query builder scoped to the active transactions of a ledger
+func newTransactionQuery(ledgerID int) *queryBuilder {
+	b := &queryBuilder{}
+	b.where("ledger_id = ?", ledgerID)
+	b.where("is_active = true")
+	return b
+}
+
+// transactionFilterReq is the filter set shared by the transaction list,
+// the monthly summary and the reports
+type transactionFilterReq struct {
+	DateStart string   `form:"date_start" json:"date_start" binding:"omitempty,datetime=2006-01-02"`
+	DateEnd   string   `form:"date_end" json:"date_end" binding:"omitempty,datetime=2006-01-02"`
+	Category  []string `form:"category" json:"category"`
+	Type      string   `form:"type" json:"type" binding:"omitempty,oneof=inflow outflow"`
+	AmountMin *int     `form:"amount_min" json:"amount_min"`
+	AmountMax *int     `form:"amount_max" json:"amount_max"`
+	Notes     string   `form:"notes" json:"notes"`
+}
+
+// isEmpty reports whether no filter is set, so the filter would match every transaction
+func (f transactionFilterReq) isEmpty() bool {
+	return f.DateStart == "" && f.DateEnd == "" && len(f.categories()) == 0 && f.Type == "" &&
+		f.AmountMin == nil && f.AmountMax == nil && f.Notes == ""
+}
+
+// categories returns the requested categories, accepting both
+// repeated (?category=a&category=b) and comma separated (?category=a,b) values
+func (f transactionFilterReq) categories() []string {
+	var result []string
+	for _, value := range f.Category {
+		for _, category := range strings.Split(value, ",") {
+			if category = strings.TrimSpace(category); category != "" {
+				result = append(result, category)
+			}
+		}
+	}
+	return result
+}
+
+// apply adds every filter that is set to the query builder
+func (f transactionFilterReq) apply(b *queryBuilder) {
+	if f.DateStart != "" {
+		b.where("date >= ?", f.DateStart)
+	}
+	if f.DateEnd != "" {
+		b.where("date <= ?", f.DateEnd)
+	}
+	if categories := f.categories(); len(categories) > 0 {
+		b.where("category = ANY(?)", pq.Array(categories))
+	}
+	if f.Type != "" {
+		b.where("type = ?", f.Type)
+	}
+	if f.AmountMin != nil {
+		b.where("amount >= ?", *f.AmountMin)
+	}
+	if f.AmountMax != nil {
+		b.where("amount <= ?", *f.AmountMax)
+	}
+	if f.Notes != "" {
+		b.where(`notes ILIKE ? ESCAPE '\'`, "%"+escapeLike(f.Notes)+"%")
+	}
+}
+
+// escapeLike escapes the LIKE wildcards so the value is matched literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
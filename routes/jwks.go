@@ -0,0 +1,19 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/utils"
+)
+
+// GetJWKS publishes the public signing keys, so other services can verify xwing tokens.
+// The body is a plain JWK set as expected by JWT libraries, without the usual envelope.
+func GetJWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, gin.H{
+			"keys": utils.PublicJWKS(),
+		})
+	}
+}
@@ -0,0 +1,628 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/mailer"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+)
+
+// LedgerHeader selects the ledger of a request, the user's personal ledger is used without it
+const LedgerHeader = "X-Ledger-ID"
+
+const ledgerInvitationDuration = 7 * 24 * time.Hour
+
+// createPersonalLedger creates the personal ledger of a new user
+func createPersonalLedger(tx *sql.Tx, userID int) error {
+	var ledgerID int
+	err := tx.QueryRow(`
+		INSERT INTO swordfish.ledgers (name, is_personal, created_by, created_at, updated_at)
+		VALUES ('Personal', true, $1, $2, $2)
+		RETURNING id
+	`, userID, time.Now()).Scan(&ledgerID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO swordfish.ledger_members (ledger_id, user_id, role, created_at)
+		VALUES ($1, $2, 'owner', $3)
+	`, ledgerID, userID, time.Now())
+	return err
+}
+
+// ledgerRole returns the role of the user in the ledger, sql.ErrNoRows when the user is not a member
+func ledgerRole(db *sql.DB, ledgerID int, userID float64) (string, error) {
+	var role string
+	err := db.QueryRow(`SELECT role FROM swordfish.ledger_members WHERE ledger_id = $1 AND user_id = $2`, ledgerID, userID).Scan(&role)
+	return role, err
+}
+
+// LedgerAccess resolves the ledger of the request and checks the membership of the user.
+// Viewers may only read, writes need the editor or owner role.
+func LedgerAccess(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		var (
+			ledgerID int
+			role     string
+			err      error
+		)
+		if header := c.GetHeader(LedgerHeader); header != "" {
+			ledgerID, err = strconv.Atoi(header)
+			if err != nil {
+				utils.RespondError(c, http.StatusBadRequest, "Invalid ledger!", LedgerHeader+" must be an integer")
+				c.Abort()
+				return
+			}
+			role, err = ledgerRole(db, ledgerID, userID)
+		} else {
+			err = db.QueryRow(`
+				SELECT l.id, m.role
+				FROM swordfish.ledgers AS l
+				JOIN swordfish.ledger_members AS m ON m.ledger_id = l.id AND m.user_id = l.created_by
+				WHERE l.created_by = $1 AND l.is_personal
+			`, userID).Scan(&ledgerID, &role)
+		}
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Ledger not found", "")
+			c.Abort()
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			c.Abort()
+			return
+		}
+
+		if role == "viewer" && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			utils.RespondError(c, http.StatusForbidden, "Failed", "viewers of a ledger cannot change it")
+			c.Abort()
+			return
+		}
+
+		c.Set("ledger_id", ledgerID)
+		c.Set("ledger_role", role)
+		c.Next()
+	}
+}
+
+// requireLedgerOwner checks the :id ledger belongs to the user as owner,
+// responding with 404 or 403 and returning false otherwise
+func requireLedgerOwner(c *gin.Context, db *sql.DB, ledgerID int) bool {
+	userID, _ := c.MustGet("user_id").(float64)
+
+	role, err := ledgerRole(db, ledgerID, userID)
+	if err == sql.ErrNoRows {
+		utils.RespondError(c, http.StatusNotFound, "Ledger not found", "")
+		return false
+	} else if err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+		return false
+	}
+	if role != "owner" {
+		utils.RespondError(c, http.StatusForbidden, "Failed", "only owners can manage the ledger")
+		return false
+	}
+	return true
+}
+
+const ledgerColumns = `l.id, l.name, l.is_personal, l.created_by, m.role, l.created_at, l.updated_at`
+
+func scanLedger(row interface{ Scan(...any) error }, ledger *models.LedgerSchema) error {
+	return row.Scan(
+		&ledger.ID,
+		&ledger.Name,
+		&ledger.IsPersonal,
+		&ledger.CreatedBy,
+		&ledger.Role,
+		&ledger.CreatedAt,
+		&ledger.UpdatedAt,
+	)
+}
+
+// GetLedgers lists the ledgers the user is a member of, with the user's role
+func GetLedgers(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ledgers := []models.LedgerSchema{}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		query := `
+			SELECT ` + ledgerColumns + `
+			FROM swordfish.ledgers AS l
+			JOIN swordfish.ledger_members AS m ON m.ledger_id = l.id
+			WHERE m.user_id = $1
+			ORDER BY l.is_personal DESC, l.name ASC
+		`
+		rows, err := db.Query(query, userID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch ledgers!", err.Error())
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var ledger models.LedgerSchema
+			if err := scanLedger(rows, &ledger); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse ledger data!", err.Error())
+				return
+			}
+			ledgers = append(ledgers, ledger)
+		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over ledgers!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    ledgers,
+		})
+	}
+}
+
+// GetLedgerById returns a ledger with its members
+func GetLedgerById(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		var ledger models.LedgerSchema
+		query := `
+			SELECT ` + ledgerColumns + `
+			FROM swordfish.ledgers AS l
+			JOIN swordfish.ledger_members AS m ON m.ledger_id = l.id
+			WHERE l.id = $1 AND m.user_id = $2
+		`
+		err := scanLedger(db.QueryRow(query, id, userID), &ledger)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Ledger not found", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		members := []models.LedgerMemberSchema{}
+		rows, err := db.Query(`
+			SELECT u.id, u.username, u.email, m.role, m.created_at
+			FROM swordfish.ledger_members AS m
+			JOIN swordfish.users AS u ON u.id = m.user_id
+			WHERE m.ledger_id = $1
+			ORDER BY m.created_at ASC
+		`, id)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch ledger members!", err.Error())
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var member models.LedgerMemberSchema
+			if err := rows.Scan(&member.UserId, &member.Username, &member.Email, &member.Role, &member.CreatedAt); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse ledger member data!", err.Error())
+				return
+			}
+			members = append(members, member)
+		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over ledger members!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data": gin.H{
+				"ledger":  ledger,
+				"members": members,
+			},
+		})
+	}
+}
+
+type ledgerReq struct {
+	Name string `json:"name" binding:"required,max=64"`
+}
+
+// PostCreateLedger creates a shared ledger owned by the user
+func PostCreateLedger(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var createReq ledgerReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&createReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to create ledger!", err.Error())
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		newLedger := models.LedgerSchema{Role: "owner"}
+		err = tx.QueryRow(`
+			INSERT INTO swordfish.ledgers (name, created_by, created_at, updated_at)
+			VALUES ($1, $2, $3, $3)
+			RETURNING id, name, is_personal, created_by, created_at, updated_at
+		`, createReq.Name, userID, time.Now()).
+			Scan(&newLedger.ID, &newLedger.Name, &newLedger.IsPersonal, &newLedger.CreatedBy, &newLedger.CreatedAt, &newLedger.UpdatedAt)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to insert ledger into database!", err.Error())
+			return
+		}
+		_, err = tx.Exec(`
+			INSERT INTO swordfish.ledger_members (ledger_id, user_id, role, created_at)
+			VALUES ($1, $2, 'owner', $3)
+		`, newLedger.ID, userID, time.Now())
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success create ledger!",
+			"data":    newLedger,
+		})
+	}
+}
+
+// PutUpdateLedger renames a ledger, only owners may do so
+func PutUpdateLedger(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var updateReq ledgerReq
+
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&updateReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update ledger!", err.Error())
+			return
+		}
+
+		if !requireLedgerOwner(c, db, id) {
+			return
+		}
+
+		if _, err := db.Exec(`UPDATE swordfish.ledgers SET name = $1, updated_at = $2 WHERE id = $3`, updateReq.Name, time.Now(), id); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success update ledger!",
+		})
+	}
+}
+
+type ledgerMemberURI struct {
+	ID     string `uri:"id" binding:"required"`
+	UserID int    `uri:"user_id" binding:"required"`
+}
+
+type ledgerMemberReq struct {
+	Role string `json:"role" binding:"required,oneof=owner editor viewer"`
+}
+
+// countOtherOwners counts the owners of the ledger besides the given user
+func countOtherOwners(db *sql.DB, ledgerID, userID int) (int, error) {
+	var owners int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM swordfish.ledger_members
+		WHERE ledger_id = $1 AND role = 'owner' AND user_id <> $2
+	`, ledgerID, userID).Scan(&owners)
+	return owners, err
+}
+
+// PutUpdateLedgerMember changes the role of a member, a ledger always keeps at least one owner
+func PutUpdateLedgerMember(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var uri ledgerMemberURI
+		var memberReq ledgerMemberReq
+
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+		if err := c.ShouldBindUri(&uri); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid URI parameter!", err.Error())
+			return
+		}
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&memberReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update ledger member!", err.Error())
+			return
+		}
+
+		if !requireLedgerOwner(c, db, id) {
+			return
+		}
+
+		if memberReq.Role != "owner" {
+			owners, err := countOtherOwners(db, id, uri.UserID)
+			if err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+				return
+			}
+			if owners == 0 {
+				utils.RespondError(c, http.StatusConflict, "Failed to update ledger member!", "a ledger needs at least one owner")
+				return
+			}
+		}
+
+		result, err := db.Exec(`UPDATE swordfish.ledger_members SET role = $1 WHERE ledger_id = $2 AND user_id = $3`, memberReq.Role, id, uri.UserID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error checking update result", err.Error())
+			return
+		}
+		if rowsAffected == 0 {
+			utils.RespondError(c, http.StatusNotFound, "Ledger member not found", "")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success update ledger member!",
+		})
+	}
+}
+
+// DeleteLedgerMember removes a member from the ledger. Owners can remove anyone,
+// every member can leave. Transactions the member created stay in the ledger.
+func DeleteLedgerMember(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var uri ledgerMemberURI
+
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+		if err := c.ShouldBindUri(&uri); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid URI parameter!", err.Error())
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		if uri.UserID == int(userID) {
+			if _, err := ledgerRole(db, id, userID); err == sql.ErrNoRows {
+				utils.RespondError(c, http.StatusNotFound, "Ledger not found", "")
+				return
+			} else if err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+				return
+			}
+		} else if !requireLedgerOwner(c, db, id) {
+			return
+		}
+
+		owners, err := countOtherOwners(db, id, uri.UserID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if owners == 0 {
+			utils.RespondError(c, http.StatusConflict, "Failed to remove ledger member!", "a ledger needs at least one owner")
+			return
+		}
+
+		result, err := db.Exec(`DELETE FROM swordfish.ledger_members WHERE ledger_id = $1 AND user_id = $2`, id, uri.UserID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error checking delete result", err.Error())
+			return
+		}
+		if rowsAffected == 0 {
+			utils.RespondError(c, http.StatusNotFound, "Ledger member not found", "")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Ledger member removed successfully",
+		})
+	}
+}
+
+type ledgerInvitationReq struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role" binding:"required,oneof=editor viewer"`
+}
+
+// PostCreateLedgerInvitation emails an invitation link to join the ledger
+func PostCreateLedgerInvitation(db *sql.DB, mail mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var inviteReq ledgerInvitationReq
+
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&inviteReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to invite to ledger!", err.Error())
+			return
+		}
+
+		if !requireLedgerOwner(c, db, id) {
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		var ledgerName string
+		var isPersonal, isMember bool
+		err := db.QueryRow(`
+			SELECT l.name, l.is_personal, EXISTS (
+				SELECT 1 FROM swordfish.ledger_members AS m
+				JOIN swordfish.users AS u ON u.id = m.user_id
+				WHERE m.ledger_id = l.id AND LOWER(u.email) = LOWER($2)
+			)
+			FROM swordfish.ledgers AS l
+			WHERE l.id = $1
+		`, id, inviteReq.Email).Scan(&ledgerName, &isPersonal, &isMember)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if isPersonal {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to invite to ledger!", "personal ledgers cannot be shared, create a shared ledger")
+			return
+		}
+		if isMember {
+			utils.RespondError(c, http.StatusConflict, "Failed to invite to ledger!", "the user is already a member of the ledger")
+			return
+		}
+
+		token, err := utils.RandomToken(32)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to generate invitation token", err.Error())
+			return
+		}
+
+		var invitation models.LedgerInvitationSchema
+		err = db.QueryRow(`
+			INSERT INTO swordfish.ledger_invitations (ledger_id, email, role, token_hash, invited_by, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			RETURNING id, ledger_id, email, role, invited_by, expires_at, accepted_at, created_at
+		`, id, strings.ToLower(inviteReq.Email), inviteReq.Role, utils.HashToken(token), userID, time.Now().Add(ledgerInvitationDuration), time.Now()).
+			Scan(&invitation.ID, &invitation.LedgerId, &invitation.Email, &invitation.Role, &invitation.InvitedBy,
+				&invitation.ExpiresAt, &invitation.AcceptedAt, &invitation.CreatedAt)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to insert invitation into database!", err.Error())
+			return
+		}
+
+		email, _ := c.MustGet("email").(string)
+		link := appLink("/ledger-invitation?token=" + url.QueryEscape(token))
+		body := fmt.Sprintf("%s invited you to the Xwing ledger \"%s\" as %s.\n\nOpen this link within %d days to accept, sign up first with this email if you have no account:\n%s",
+			email, ledgerName, inviteReq.Role, int(ledgerInvitationDuration.Hours()/24), link)
+		if err := mail.Send(inviteReq.Email, "You were invited to an Xwing ledger", body); err != nil {
+			log.Printf("[ledger][invitation] failed to send invitation email: %v", err)
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to send invitation email", "")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success invite to ledger!",
+			"data":    invitation,
+		})
+	}
+}
+
+type acceptLedgerInvitationReq struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// PostAcceptLedgerInvitation adds the user to the ledger of an invitation sent to the user's email
+func PostAcceptLedgerInvitation(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var acceptReq acceptLedgerInvitationReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&acceptReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to accept invitation!", err.Error())
+			return
+		}
+
+		// get userid and email jwt
+		userID, _ := c.MustGet("user_id").(float64)
+		email, _ := c.MustGet("email").(string)
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		// consume the invitation, only the invited email can accept it
+		var ledgerID int
+		var role string
+		err = tx.QueryRow(`
+			UPDATE swordfish.ledger_invitations
+			SET accepted_at = $1
+			WHERE token_hash = $2 AND email = LOWER($3)
+				AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > $1
+			RETURNING ledger_id, role
+		`, time.Now(), utils.HashToken(acceptReq.Token), email).Scan(&ledgerID, &role)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid or expired invitation", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO swordfish.ledger_members (ledger_id, user_id, role, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (ledger_id, user_id) DO NOTHING
+		`, ledgerID, userID, role, time.Now())
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success accept invitation!",
+			"data": gin.H{
+				"ledger_id": ledgerID,
+				"role":      role,
+			},
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loginThrottle describes the backoff of one key (email or ip): the first freeAttempts
+// failures inside window are not delayed, every further failure doubles the wait up to maxWait.
+// resetOnSuccess forgets the failures before the last successful login of the key, it is only
+// safe for the email, anyone can log into their own account from a shared ip.
+type loginThrottle struct {
+	column         string
+	freeAttempts   int
+	window         time.Duration
+	maxWait        time.Duration
+	resetOnSuccess bool
+}
+
+var (
+	emailThrottle = loginThrottle{column: "LOWER(email)", freeAttempts: 3, window: time.Hour, maxWait: 15 * time.Minute, resetOnSuccess: true}
+	ipThrottle    = loginThrottle{column: "ip", freeAttempts: 20, window: 15 * time.Minute, maxWait: 15 * time.Minute}
+)
+
+// dummyPasswordHash is compared against when the email is unknown, so the response
+// takes as long as a wrong password and does not reveal which emails are registered
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("xwing-unknown-user"), bcrypt.DefaultCost)
+
+// wait returns how long the key has to wait before the next attempt is allowed.
+// Failures are counted inside the window, reserved attempts still in progress count as failures.
+func (t loginThrottle) wait(tx *sql.Tx, key string, now time.Time) (time.Duration, error) {
+	var failures int
+	var lastFailure *time.Time
+	query := fmt.Sprintf(`
+		SELECT COUNT(*), MAX(created_at)
+		FROM swordfish.login_attempts
+		WHERE %s = $1 AND success = false AND created_at > $2
+	`, t.column)
+	if t.resetOnSuccess {
+		query += fmt.Sprintf(`
+			AND created_at > COALESCE((
+				SELECT MAX(created_at) FROM swordfish.login_attempts WHERE %s = $1 AND success = true
+			), '-infinity')
+		`, t.column)
+	}
+	if err := tx.QueryRow(query, key, now.Add(-t.window)).Scan(&failures, &lastFailure); err != nil {
+		return 0, err
+	}
+	if failures < t.freeAttempts || lastFailure == nil {
+		return 0, nil
+	}
+
+	backoff := time.Duration(math.Pow(2, float64(failures-t.freeAttempts))) * time.Second
+	if backoff > t.maxWait || backoff <= 0 {
+		backoff = t.maxWait
+	}
+	if remaining := lastFailure.Add(backoff).Sub(now); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// reserveLoginAttempt checks the backoff of the email and ip and stores the attempt as failed
+// before the credentials are checked, so parallel requests cannot all pass the same check.
+// It responds with 429 and returns false when the email or ip is locked out. The returned id
+// is passed to completeLoginAttempt or releaseLoginAttempt once the credentials are known to be right.
+func reserveLoginAttempt(c *gin.Context, db *sql.DB, email string) (int, bool) {
+	email = strings.ToLower(email)
+	ip := c.ClientIP()
+
+	tx, err := db.Begin()
+	if err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+		return 0, false
+	}
+	defer tx.Rollback()
+
+	// attempts on the same email or ip wait here for each other, always in the same order
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('login:email:' || $1)), pg_advisory_xact_lock(hashtext('login:ip:' || $2))`, email, ip); err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+		return 0, false
+	}
+
+	now := time.Now()
+	emailWait, err := emailThrottle.wait(tx, email, now)
+	if err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+		return 0, false
+	}
+	ipWait, err := ipThrottle.wait(tx, ip, now)
+	if err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+		return 0, false
+	}
+
+	wait := max(emailWait, ipWait)
+	if wait > 0 {
+		seconds := int(math.Ceil(wait.Seconds()))
+		c.Header("Retry-After", fmt.Sprint(seconds))
+		utils.RespondError(c, http.StatusTooManyRequests, "Too many failed login attempts", fmt.Sprintf("try again in %d seconds", seconds))
+		return 0, false
+	}
+
+	var attemptID int
+	err = tx.QueryRow(`
+		INSERT INTO swordfish.login_attempts (email, ip, user_agent, success, created_at)
+		VALUES ($1, $2, $3, false, $4)
+		RETURNING id
+	`, email, ip, c.Request.UserAgent(), now).Scan(&attemptID)
+	if err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+		return 0, false
+	}
+	if err := tx.Commit(); err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+		return 0, false
+	}
+	return attemptID, true
+}
+
+// completeLoginAttempt marks a reserved attempt as a successful login
+func completeLoginAttempt(db *sql.DB, attemptID int) error {
+	_, err := db.Exec(`UPDATE swordfish.login_attempts SET success = true WHERE id = $1`, attemptID)
+	return err
+}
+
+// releaseLoginAttempt removes a reserved attempt whose credentials were right but that did not
+// log in yet, e.g. the second factor is still missing, so it does not count as a failure
+func releaseLoginAttempt(db *sql.DB, attemptID int) error {
+	_, err := db.Exec(`DELETE FROM swordfish.login_attempts WHERE id = $1`, attemptID)
+	return err
+}
+
+// recordLoginAttempt stores the outcome of a login attempt that was not reserved
+func recordLoginAttempt(db *sql.DB, c *gin.Context, email string, success bool) error {
+	_, err := db.Exec(`
+		INSERT INTO swordfish.login_attempts (email, ip, user_agent, success, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, strings.ToLower(email), c.ClientIP(), c.Request.UserAgent(), success, time.Now())
+	return err
+}
+
+type loginAttempt struct {
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type loginAttemptQueryReq struct {
+	All bool `form:"all"`
+}
+
+// GetLoginAttempts lists the recent failed login attempts on the user's email,
+// all=true includes the successful ones
+func GetLoginAttempts(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var queryReq loginAttemptQueryReq
+		attempts := []loginAttempt{}
+
+		// Bind query parameters
+		if err := c.BindQuery(&queryReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid query parameters!", err.Error())
+			return
+		}
+
+		// get email jwt
+		email, _ := c.MustGet("email").(string)
+
+		query := `
+			SELECT ip, COALESCE(user_agent, ''), success, created_at
+			FROM swordfish.login_attempts
+			WHERE LOWER(email) = $1 AND created_at > $2 AND (success = false OR $3)
+			ORDER BY created_at DESC
+			LIMIT 50
+		`
+		rows, err := db.Query(query, strings.ToLower(email), time.Now().AddDate(0, 0, -30), queryReq.All)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch login attempts!", err.Error())
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var attempt loginAttempt
+			if err := rows.Scan(&attempt.IP, &attempt.UserAgent, &attempt.Success, &attempt.CreatedAt); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse login attempt data!", err.Error())
+				return
+			}
+			attempts = append(attempts, attempt)
+		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over login attempts!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    attempts,
+		})
+	}
+}
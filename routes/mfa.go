@@ -0,0 +1,403 @@
+package routes
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	mfaIssuer         = "Xwing"
+	recoveryCodeCount = 10
+)
+
+// isMFAEnabled reports whether the user has confirmed TOTP enrollment
+func isMFAEnabled(db *sql.DB, userID int) (bool, error) {
+	var enabled bool
+	err := db.QueryRow(`
+		SELECT enabled_at IS NOT NULL
+		FROM swordfish.user_mfa
+		WHERE user_id = $1
+	`, userID).Scan(&enabled)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return enabled, err
+}
+
+// verifyTOTP checks a code against the user's secret and records its time step,
+// so the same code cannot be used twice. pending selects an unconfirmed enrollment.
+func verifyTOTP(tx *sql.Tx, userID int, code string, pending bool) (bool, error) {
+	var secret string
+	var lastStep int64
+	err := tx.QueryRow(`
+		SELECT secret, last_used_step
+		FROM swordfish.user_mfa
+		WHERE user_id = $1 AND (enabled_at IS NULL) = $2
+		FOR UPDATE
+	`, userID, pending).Scan(&secret, &lastStep)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok || step <= lastStep {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`UPDATE swordfish.user_mfa SET last_used_step = $1 WHERE user_id = $2`, step, userID)
+	return err == nil, err
+}
+
+// useRecoveryCode consumes one unused recovery code of the user
+func useRecoveryCode(tx *sql.Tx, userID int, code string) (bool, error) {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	result, err := tx.Exec(`
+		UPDATE swordfish.mfa_recovery_codes
+		SET used_at = $1
+		WHERE id = (
+			SELECT id FROM swordfish.mfa_recovery_codes
+			WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL
+			LIMIT 1
+		)
+	`, time.Now(), userID, utils.HashToken(code))
+	if err != nil {
+		return false, err
+	}
+	rowsAffected, err := result.RowsAffected()
+	return rowsAffected > 0, err
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and returns a fresh set, shown only once
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec(`DELETE FROM swordfish.mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 5)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, err
+		}
+		code := hex.EncodeToString(raw)
+		_, err := tx.Exec(`
+			INSERT INTO swordfish.mfa_recovery_codes (user_id, code_hash, created_at)
+			VALUES ($1, $2, $3)
+		`, userID, utils.HashToken(code), time.Now())
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+	return codes, nil
+}
+
+// PostSetupMFA starts TOTP enrollment and returns the secret and otpauth URI,
+// it is not enforced on login until confirmed with a valid code
+func PostSetupMFA(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+		email, _ := c.MustGet("email").(string)
+
+		enabled, err := isMFAEnabled(db, int(userID))
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if enabled {
+			utils.RespondError(c, http.StatusConflict, "Two-factor authentication is already enabled", "")
+			return
+		}
+
+		secret, err := utils.GenerateTOTPSecret()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to generate secret", err.Error())
+			return
+		}
+
+		// restarting the enrollment replaces the previous pending secret
+		_, err = db.Exec(`
+			INSERT INTO swordfish.user_mfa (user_id, secret, created_at)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = EXCLUDED.created_at
+			WHERE swordfish.user_mfa.enabled_at IS NULL
+		`, userID, secret, time.Now())
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Scan the QR code and confirm with a code from your authenticator app",
+			"data": gin.H{
+				"secret":      secret,
+				"otpauth_uri": utils.TOTPURI(secret, mfaIssuer, email),
+			},
+		})
+	}
+}
+
+type mfaCodeReq struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// PostConfirmMFA enables two-factor authentication and returns the recovery codes
+func PostConfirmMFA(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var codeReq mfaCodeReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&codeReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to confirm two-factor authentication!", err.Error())
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		valid, err := verifyTOTP(tx, int(userID), codeReq.Code, true)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if !valid {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid code or no pending enrollment", "")
+			return
+		}
+
+		if _, err := tx.Exec(`UPDATE swordfish.user_mfa SET enabled_at = $1 WHERE user_id = $2`, time.Now(), userID); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		codes, err := replaceRecoveryCodes(tx, int(userID))
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to generate recovery codes", err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Two-factor authentication enabled, store the recovery codes somewhere safe",
+			"data": gin.H{
+				"recovery_codes": codes,
+			},
+		})
+	}
+}
+
+// PostRegenerateRecoveryCodes replaces the recovery codes, it requires a current TOTP code
+func PostRegenerateRecoveryCodes(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var codeReq mfaCodeReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&codeReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to regenerate recovery codes!", err.Error())
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		valid, err := verifyTOTP(tx, int(userID), codeReq.Code, false)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if !valid {
+			utils.RespondError(c, http.StatusUnauthorized, "Invalid code", "")
+			return
+		}
+
+		codes, err := replaceRecoveryCodes(tx, int(userID))
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to generate recovery codes", err.Error())
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success regenerate recovery codes!",
+			"data": gin.H{
+				"recovery_codes": codes,
+			},
+		})
+	}
+}
+
+type disableMFAReq struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// PostDisableMFA turns off two-factor authentication, it requires the password and a current code
+func PostDisableMFA(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var disableReq disableMFAReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&disableReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to disable two-factor authentication!", err.Error())
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		var hashedPassword string
+		if err := db.QueryRow(`SELECT password FROM swordfish.users WHERE id = $1`, userID).Scan(&hashedPassword); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(disableReq.Password)) != nil {
+			utils.RespondError(c, http.StatusUnauthorized, "Invalid password or code", "")
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		valid, err := verifyTOTP(tx, int(userID), disableReq.Code, false)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if !valid {
+			utils.RespondError(c, http.StatusUnauthorized, "Invalid password or code", "")
+			return
+		}
+
+		if _, err := tx.Exec(`DELETE FROM swordfish.mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if _, err := tx.Exec(`DELETE FROM swordfish.user_mfa WHERE user_id = $1`, userID); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Two-factor authentication disabled",
+		})
+	}
+}
+
+type loginMFAReq struct {
+	MFAToken     string `json:"mfa_token" binding:"required"`
+	Code         string `json:"code" binding:"required_without=RecoveryCode"`
+	RecoveryCode string `json:"recovery_code" binding:"required_without=Code"`
+}
+
+// LoginMFA is the second login step, it exchanges the challenge token from LoginUser
+// and a TOTP or recovery code for the regular access and refresh tokens
+func LoginMFA(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var loginReq loginMFAReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&loginReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to login!", err.Error())
+			return
+		}
+
+		userID, err := utils.ParseMFAToken(loginReq.MFAToken)
+		if err != nil {
+			utils.RespondError(c, http.StatusUnauthorized, "Invalid or expired MFA token", "")
+			return
+		}
+
+		var email string
+		if err := db.QueryRow(`SELECT email FROM swordfish.users WHERE id = $1`, userID).Scan(&email); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		// wrong codes count as failed logins of the account
+		attemptID, ok := reserveLoginAttempt(c, db, email)
+		if !ok {
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		var valid bool
+		if loginReq.Code != "" {
+			valid, err = verifyTOTP(tx, userID, loginReq.Code, false)
+		} else {
+			valid, err = useRecoveryCode(tx, userID, loginReq.RecoveryCode)
+		}
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if !valid {
+			utils.RespondError(c, http.StatusUnauthorized, "Invalid code", "")
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if err := completeLoginAttempt(db, attemptID); err != nil {
+			log.Printf("[auth][mfa] failed to record login attempt: %v", err)
+		}
+
+		tokens, err := issueTokens(db, c, userID, email)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to generate token", "[auth][jwt]"+err.Error())
+			return
+		}
+
+		respondTokens(c, "Success Login!", tokens)
+	}
+}
@@ -0,0 +1,66 @@
+package routes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/halosatrio/xwing/utils"
+)
+
+// mfaTestSecret is the RFC 6238 SHA1 key in base32
+const mfaTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestVerifyTOTPRejectsReplay(t *testing.T) {
+	// the step can only move forward during the test, a code of the previous step still validates
+	now := time.Now()
+	step := now.Unix() / 30
+	code, err := utils.TOTPCode(mfaTestSecret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		lastStep int64
+		want     bool
+	}{
+		{"unused code", step - 2, true},
+		{"code of the last used step", step, false},
+		{"code older than the last used step", step + 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("FROM swordfish.user_mfa").
+				WithArgs(7, false).
+				WillReturnRows(sqlmock.NewRows([]string{"secret", "last_used_step"}).AddRow(mfaTestSecret, tt.lastStep))
+			if tt.want {
+				mock.ExpectExec("UPDATE swordfish.user_mfa SET last_used_step").
+					WithArgs(sqlmock.AnyArg(), 7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			valid, err := verifyTOTP(tx, 7, code, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if valid != tt.want {
+				t.Fatalf("verifyTOTP = %v, want %v", valid, tt.want)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/sso"
+	"github.com/halosatrio/xwing/utils"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/oauth2"
+)
+
+// oidcLoginDuration is how long the user has to come back from the identity provider
+const oidcLoginDuration = 10 * time.Minute
+
+// oidcStateCookie binds a login to the browser that started it, it holds the hash of the state.
+// Without it an attacker could get the victim's browser to finish the attacker's own login.
+const (
+	oidcStateCookie     = "xwing_oidc_state"
+	oidcStateCookiePath = "/v1/auth/oidc"
+)
+
+// setOIDCStateCookie stores the state hash in an HttpOnly cookie, an empty value removes the cookie
+func setOIDCStateCookie(c *gin.Context, value string, maxAge int) {
+	secure := strings.HasPrefix(appLink(""), "https://")
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, value, maxAge, oidcStateCookiePath, "", secure, true)
+}
+
+// OIDCRedirectURL is the default redirect URL of the identity provider, the OIDC callback page of the frontend
+func OIDCRedirectURL() string {
+	return appLink("/oidc/callback")
+}
+
+// oidcAutoRegister creates an account for unknown verified emails, set OIDC_AUTO_REGISTER=true to enable it
+func oidcAutoRegister() bool {
+	return os.Getenv("OIDC_AUTO_REGISTER") == "true"
+}
+
+// GetOIDCLogin starts an OpenID Connect login and returns the authorization URL of the identity provider.
+// The provider redirects back to the frontend, which posts the code and state to PostOIDCCallback.
+func GetOIDCLogin(db *sql.DB, provider *sso.Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if provider == nil {
+			utils.RespondError(c, http.StatusNotFound, "OIDC login is not configured", "")
+			return
+		}
+
+		state, err := utils.RandomToken(32)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to start login", err.Error())
+			return
+		}
+		nonce, err := utils.RandomToken(32)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to start login", err.Error())
+			return
+		}
+		verifier := oauth2.GenerateVerifier()
+
+		authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+		if err != nil {
+			log.Printf("[auth][oidc] discovery failed: %v", err)
+			utils.RespondError(c, http.StatusBadGateway, "Identity provider is not reachable", "")
+			return
+		}
+
+		// drop the logins that were never finished
+		if _, err := db.Exec(`DELETE FROM swordfish.oidc_logins WHERE expires_at < $1`, time.Now()); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		_, err = db.Exec(`
+			INSERT INTO swordfish.oidc_logins (state_hash, code_verifier, nonce, expires_at, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`, utils.HashToken(state), verifier, nonce, time.Now().Add(oidcLoginDuration), time.Now())
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		setOIDCStateCookie(c, utils.HashToken(state), int(oidcLoginDuration.Seconds()))
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data": gin.H{
+				"authorization_url": authURL,
+				"state":             state,
+			},
+		})
+	}
+}
+
+type oidcCallbackReq struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+// PostOIDCCallback finishes an OpenID Connect login and issues the regular xwing tokens.
+// The state must match the cookie set by GetOIDCLogin in the same browser.
+// The external subject is linked to the user with the same email the first time,
+// which requires the identity provider to have verified that email.
+func PostOIDCCallback(db *sql.DB, provider *sso.Provider) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var callbackReq oidcCallbackReq
+
+		if provider == nil {
+			utils.RespondError(c, http.StatusNotFound, "OIDC login is not configured", "")
+			return
+		}
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&callbackReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to login!", err.Error())
+			return
+		}
+
+		// the login must have been started by this browser
+		stateHash := utils.HashToken(callbackReq.State)
+		cookie, err := c.Cookie(oidcStateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(cookie), []byte(stateHash)) != 1 {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid or expired login state", "login was not started in this browser")
+			return
+		}
+		setOIDCStateCookie(c, "", -1)
+
+		// consume the state, a login can only be finished once
+		var verifier, nonce string
+		err = db.QueryRow(`
+			DELETE FROM swordfish.oidc_logins
+			WHERE state_hash = $1 AND expires_at > $2
+			RETURNING code_verifier, nonce
+		`, stateHash, time.Now()).Scan(&verifier, &nonce)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid or expired login state", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		identity, err := provider.Exchange(c.Request.Context(), callbackReq.Code, verifier, nonce)
+		if err != nil {
+			log.Printf("[auth][oidc] code exchange failed: %v", err)
+			utils.RespondError(c, http.StatusUnauthorized, "Identity provider login failed", "")
+			return
+		}
+
+		userID, email, err := linkOIDCIdentity(db, identity)
+		if err == errOIDCNoAccount {
+			utils.RespondError(c, http.StatusForbidden, "No account for this identity", "sign up first or ask for automatic registration to be enabled")
+			return
+		} else if err == errOIDCUnverifiedEmail {
+			utils.RespondError(c, http.StatusForbidden, "The identity provider has not verified this email", "")
+			return
+		} else if err == errOIDCUnverifiedAccount {
+			utils.RespondError(c, http.StatusConflict, "Verify the email of your account before signing in with the identity provider", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		// the password step is replaced by the identity provider, two-factor authentication still applies
+		mfaEnabled, err := isMFAEnabled(db, userID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if mfaEnabled {
+			mfaToken, err := utils.GenerateMFAToken(userID)
+			if err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to generate token", "[auth][jwt]"+err.Error())
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"status":  http.StatusOK,
+				"message": "Two-factor authentication code required",
+				"data": gin.H{
+					"mfa_required": true,
+					"mfa_token":    mfaToken,
+				},
+			})
+			return
+		}
+
+		if err := recordLoginAttempt(db, c, email, true); err != nil {
+			log.Printf("[auth][oidc] failed to record login attempt: %v", err)
+		}
+
+		tokens, err := issueTokens(db, c, userID, email)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to generate token", "[auth][jwt]"+err.Error())
+			return
+		}
+
+		respondTokens(c, "Success Login!", tokens)
+	}
+}
+
+type oidcLinkError string
+
+func (e oidcLinkError) Error() string { return string(e) }
+
+const (
+	errOIDCNoAccount       = oidcLinkError("no account for this identity")
+	errOIDCUnverifiedEmail = oidcLinkError("email is not verified by the identity provider")
+	// the local account may have been registered by someone else with this email,
+	// linking would leave their password working on the account
+	errOIDCUnverifiedAccount = oidcLinkError("the account with this email has not verified it")
+)
+
+// linkOIDCIdentity returns the user of an external identity. Unknown identities are linked
+// to the user with the same email when both sides verified it, or registered when
+// OIDC_AUTO_REGISTER is enabled.
+func linkOIDCIdentity(db *sql.DB, identity sso.Identity) (int, string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var email string
+	err = tx.QueryRow(`
+		SELECT u.id, u.email
+		FROM swordfish.user_identities AS i
+		JOIN swordfish.users AS u ON u.id = i.user_id
+		WHERE i.issuer = $1 AND i.subject = $2 AND u.deleted_at IS NULL
+	`, identity.Issuer, identity.Subject).Scan(&userID, &email)
+	if err == nil {
+		if _, err := tx.Exec(`UPDATE swordfish.user_identities SET last_login_at = $1 WHERE issuer = $2 AND subject = $3`,
+			time.Now(), identity.Issuer, identity.Subject); err != nil {
+			return 0, "", err
+		}
+		return userID, email, tx.Commit()
+	} else if err != sql.ErrNoRows {
+		return 0, "", err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return 0, "", errOIDCUnverifiedEmail
+	}
+
+	var emailVerified bool
+	err = tx.QueryRow(`
+		SELECT id, email, email_verified_at IS NOT NULL FROM swordfish.users
+		WHERE LOWER(email) = LOWER($1) AND deleted_at IS NULL
+	`, identity.Email).Scan(&userID, &email, &emailVerified)
+	if err == sql.ErrNoRows {
+		if !oidcAutoRegister() {
+			return 0, "", errOIDCNoAccount
+		}
+		userID, email, err = registerOIDCUser(tx, identity.Email)
+	} else if err == nil && !emailVerified {
+		return 0, "", errOIDCUnverifiedAccount
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO swordfish.user_identities (user_id, issuer, subject, email, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+	`, userID, identity.Issuer, identity.Subject, identity.Email, time.Now())
+	if err != nil {
+		return 0, "", err
+	}
+
+	return userID, email, tx.Commit()
+}
+
+// registerOIDCUser creates an account without a usable password for an identity provider login
+func registerOIDCUser(tx *sql.Tx, email string) (int, string, error) {
+	password, err := utils.RandomToken(32)
+	if err != nil {
+		return 0, "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, "", err
+	}
+
+	var userID int
+	err = tx.QueryRow(`
+		INSERT INTO swordfish.users (username, email, password, email_verified_at, created_at)
+		VALUES ($1, $2, $3, $4, $4)
+		RETURNING id
+	`, email, email, string(hashedPassword), time.Now()).Scan(&userID)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if err := setupNewUser(tx, userID); err != nil {
+		return 0, "", err
+	}
+	return userID, email, nil
+}
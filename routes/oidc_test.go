@@ -0,0 +1,246 @@
+package routes
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/sso"
+	"github.com/halosatrio/xwing/sso/ssotest"
+)
+
+// captureArg matches any argument and keeps its value
+type captureArg struct{ value *string }
+
+func (a captureArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*a.value = s
+	return ok
+}
+
+type oidcTest struct {
+	t        *testing.T
+	mock     sqlmock.Sqlmock
+	router   *gin.Engine
+	issuer   *ssotest.Issuer
+	verifier string
+	nonce    string
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("JWT_SECRET", "test-secret")
+	t.Setenv("OIDC_AUTO_REGISTER", "")
+
+	issuer, err := ssotest.NewIssuer("xwing", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+	t.Setenv("OIDC_ISSUER_URL", issuer.URL)
+	t.Setenv("OIDC_CLIENT_ID", "xwing")
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+	provider := sso.FromEnv(OIDCRedirectURL())
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	router := gin.New()
+	router.GET("/v1/auth/oidc/login", GetOIDCLogin(db, provider))
+	router.POST("/v1/auth/oidc/callback", PostOIDCCallback(db, provider))
+	return &oidcTest{t: t, mock: mock, router: router, issuer: issuer}
+}
+
+// start runs GetOIDCLogin and signs user in at the issuer, it returns the cookie, code and state
+func (o *oidcTest) start(user ssotest.User) (*http.Cookie, string, string) {
+	o.t.Helper()
+	o.mock.ExpectExec("DELETE FROM swordfish.oidc_logins WHERE expires_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	o.mock.ExpectExec("INSERT INTO swordfish.oidc_logins").
+		WithArgs(sqlmock.AnyArg(), captureArg{&o.verifier}, captureArg{&o.nonce}, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	w := httptest.NewRecorder()
+	o.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/auth/oidc/login", nil))
+	if w.Code != http.StatusOK {
+		o.t.Fatalf("login status = %d: %s", w.Code, w.Body)
+	}
+
+	var body struct {
+		Data struct {
+			AuthorizationURL string `json:"authorization_url"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		o.t.Fatal(err)
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		o.t.Fatalf("login did not set an HttpOnly state cookie")
+	}
+
+	code, state, err := o.issuer.Authorize(body.Data.AuthorizationURL, user)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	return cookie, code, state
+}
+
+// expectConsumeState makes the state row available once, like the DELETE ... RETURNING of a stored login
+func (o *oidcTest) expectConsumeState(nonce string) {
+	o.mock.ExpectQuery("DELETE FROM swordfish.oidc_logins").
+		WillReturnRows(sqlmock.NewRows([]string{"code_verifier", "nonce"}).AddRow(o.verifier, nonce))
+}
+
+func (o *oidcTest) callback(cookie *http.Cookie, code, state string) *httptest.ResponseRecorder {
+	o.t.Helper()
+	body, _ := json.Marshal(gin.H{"code": code, "state": state})
+	req := httptest.NewRequest(http.MethodPost, "/v1/auth/oidc/callback", strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", "application/json")
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	o.router.ServeHTTP(w, req)
+	return w
+}
+
+func (o *oidcTest) done() {
+	o.t.Helper()
+	if err := o.mock.ExpectationsWereMet(); err != nil {
+		o.t.Fatal(err)
+	}
+}
+
+func TestOIDCCallbackLinksVerifiedEmail(t *testing.T) {
+	o := newOIDCTest(t)
+	cookie, code, state := o.start(ssotest.User{Subject: "u1", Email: "Budi@Example.com", EmailVerified: true})
+
+	o.expectConsumeState(o.nonce)
+	o.mock.ExpectBegin()
+	o.mock.ExpectQuery("FROM swordfish.user_identities").
+		WithArgs(o.issuer.URL, "u1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email"}))
+	o.mock.ExpectQuery("SELECT id, email, email_verified_at IS NOT NULL FROM swordfish.users").
+		WithArgs("Budi@Example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "verified"}).AddRow(7, "budi@example.com", true))
+	o.mock.ExpectExec("INSERT INTO swordfish.user_identities").
+		WithArgs(7, o.issuer.URL, "u1", "Budi@Example.com", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	o.mock.ExpectCommit()
+	o.mock.ExpectQuery("FROM swordfish.user_mfa").WillReturnRows(sqlmock.NewRows([]string{"enabled"}))
+	o.mock.ExpectExec("INSERT INTO swordfish.login_attempts").WillReturnResult(sqlmock.NewResult(1, 1))
+	o.mock.ExpectBegin()
+	o.mock.ExpectExec("INSERT INTO swordfish.sessions").WillReturnResult(sqlmock.NewResult(1, 1))
+	o.mock.ExpectExec("INSERT INTO swordfish.refresh_tokens").WillReturnResult(sqlmock.NewResult(1, 1))
+	o.mock.ExpectCommit()
+
+	w := o.callback(cookie, code, state)
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), "refresh_token") {
+		t.Fatalf("callback did not issue tokens: %s", w.Body)
+	}
+	o.done()
+}
+
+func TestOIDCCallbackRefusesUnverifiedAccount(t *testing.T) {
+	o := newOIDCTest(t)
+	cookie, code, state := o.start(ssotest.User{Subject: "u1", Email: "budi@example.com", EmailVerified: true})
+
+	// someone registered budi@example.com without verifying it, the identity must not take that account over
+	o.expectConsumeState(o.nonce)
+	o.mock.ExpectBegin()
+	o.mock.ExpectQuery("FROM swordfish.user_identities").WillReturnRows(sqlmock.NewRows([]string{"id", "email"}))
+	o.mock.ExpectQuery("SELECT id, email, email_verified_at IS NOT NULL FROM swordfish.users").
+		WithArgs("budi@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "verified"}).AddRow(7, "budi@example.com", false))
+	o.mock.ExpectRollback()
+
+	w := o.callback(cookie, code, state)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("callback status = %d, want %d: %s", w.Code, http.StatusConflict, w.Body)
+	}
+	o.done()
+}
+
+func TestOIDCCallbackRejectsUnverifiedEmail(t *testing.T) {
+	o := newOIDCTest(t)
+	cookie, code, state := o.start(ssotest.User{Subject: "u1", Email: "budi@example.com"})
+
+	o.expectConsumeState(o.nonce)
+	o.mock.ExpectBegin()
+	o.mock.ExpectQuery("FROM swordfish.user_identities").WillReturnRows(sqlmock.NewRows([]string{"id", "email"}))
+	o.mock.ExpectRollback()
+
+	w := o.callback(cookie, code, state)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("callback status = %d, want %d: %s", w.Code, http.StatusForbidden, w.Body)
+	}
+	o.done()
+}
+
+func TestOIDCCallbackRejectsNonceMismatch(t *testing.T) {
+	o := newOIDCTest(t)
+	cookie, code, state := o.start(ssotest.User{Subject: "u1", Email: "budi@example.com", EmailVerified: true})
+
+	o.expectConsumeState("another-login")
+
+	w := o.callback(cookie, code, state)
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("callback status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+	}
+	o.done()
+}
+
+func TestOIDCCallbackStateCannotBeReused(t *testing.T) {
+	o := newOIDCTest(t)
+	cookie, code, state := o.start(ssotest.User{Subject: "u1", Email: "budi@example.com"})
+
+	// the first attempt consumes the state
+	o.expectConsumeState(o.nonce)
+	o.mock.ExpectBegin()
+	o.mock.ExpectQuery("FROM swordfish.user_identities").WillReturnRows(sqlmock.NewRows([]string{"id", "email"}))
+	o.mock.ExpectRollback()
+	o.callback(cookie, code, state)
+
+	// the row is gone, so a replay finds nothing
+	o.mock.ExpectQuery("DELETE FROM swordfish.oidc_logins").
+		WillReturnRows(sqlmock.NewRows([]string{"code_verifier", "nonce"}))
+
+	w := o.callback(cookie, code, state)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("replay status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	o.done()
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	o := newOIDCTest(t)
+	_, code, state := o.start(ssotest.User{Subject: "u1", Email: "budi@example.com", EmailVerified: true})
+
+	// another browser cannot finish the login, and the state is left untouched
+	w := o.callback(nil, code, state)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("callback status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	w = o.callback(&http.Cookie{Name: oidcStateCookie, Value: "attacker"}, code, state)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("callback status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+	}
+	o.done()
+}
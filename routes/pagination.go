@@ -0,0 +1,65 @@
+package routes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// sortColumn describes a column that a list endpoint can be sorted by,
+// cast is the postgres type used to compare the cursor value against it
+type sortColumn struct {
+	column string
+	cast   string
+}
+
+var numericCursorValue = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+// validCursorValue reports whether a cursor value can be cast to the column type,
+// so a tampered cursor is rejected before it reaches the query
+func (col sortColumn) validCursorValue(value string) bool {
+	switch col.cast {
+	case "numeric":
+		return numericCursorValue.MatchString(value)
+	case "date", "timestamp":
+		_, err := time.Parse(time.RFC3339Nano, value)
+		return err == nil
+	default:
+		return false
+	}
+}
+
+// pageCursor is the decoded form of the opaque next_cursor token,
+// it points at the last row of the previous page
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+func encodeCursor(cursor pageCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(token string) (pageCursor, error) {
+	var cursor pageCursor
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, errInvalidCursor
+	}
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return cursor, errInvalidCursor
+	}
+	return cursor, nil
+}
@@ -0,0 +1,234 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/mailer"
+	"github.com/halosatrio/xwing/utils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordResetDuration = time.Hour
+
+type changePasswordReq struct {
+	OldPassword string `json:"old_password" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+// PutChangePassword changes the password of the logged in user
+// and revokes every other session
+func PutChangePassword(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var passwordReq changePasswordReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&passwordReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to change password!", err.Error())
+			return
+		}
+
+		// get userid and session jwt
+		userID, _ := c.MustGet("user_id").(float64)
+		sessionID, _ := c.MustGet("session_id").(string)
+
+		var hashedPassword string
+		err := db.QueryRow(`SELECT password FROM swordfish.users WHERE id = $1`, userID).Scan(&hashedPassword)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(passwordReq.OldPassword)) != nil {
+			utils.RespondError(c, http.StatusUnauthorized, "Old password is incorrect", "")
+			return
+		}
+
+		newHash, err := bcrypt.GenerateFromPassword([]byte(passwordReq.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to hash password!", err.Error())
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec(`UPDATE swordfish.users SET password = $1, updated_at = $2 WHERE id = $3`, string(newHash), time.Now(), userID); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to update password!", err.Error())
+			return
+		}
+		_, err = tx.Exec(`
+			UPDATE swordfish.sessions
+			SET revoked_at = $1
+			WHERE user_id = $2 AND id <> $3 AND revoked_at IS NULL
+		`, time.Now(), userID, sessionID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success change password!",
+		})
+	}
+}
+
+type forgotPasswordReq struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// PostForgotPassword emails a password reset link. It responds the same way whether
+// or not the email is registered, so it cannot be used to find accounts.
+func PostForgotPassword(db *sql.DB, mail mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var forgotReq forgotPasswordReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&forgotReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to request password reset!", err.Error())
+			return
+		}
+
+		// the lookup and the email happen after the response, so known and
+		// unknown emails take the same time to answer
+		go sendPasswordReset(db, mail, forgotReq.Email)
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "If the email is registered, a password reset link has been sent",
+		})
+	}
+}
+
+// sendPasswordReset creates a reset token for the account with the email and mails the link to it,
+// errors are only logged because the client has already been answered
+func sendPasswordReset(db *sql.DB, mail mailer.Mailer, email string) {
+	var userID int
+	var accountEmail string
+	err := db.QueryRow(`SELECT id, email FROM swordfish.users WHERE LOWER(email) = LOWER($1)`, email).Scan(&userID, &accountEmail)
+	if err == sql.ErrNoRows {
+		return
+	} else if err != nil {
+		log.Printf("[auth][password] failed to look up reset email: %v", err)
+		return
+	}
+
+	token, err := utils.RandomToken(32)
+	if err != nil {
+		log.Printf("[auth][password] failed to generate reset token: %v", err)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("[auth][password] failed to store reset token: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	// only the latest reset link stays valid
+	if _, err := tx.Exec(`UPDATE swordfish.password_resets SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL`, time.Now(), userID); err != nil {
+		log.Printf("[auth][password] failed to store reset token: %v", err)
+		return
+	}
+	_, err = tx.Exec(`
+		INSERT INTO swordfish.password_resets (user_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, userID, utils.HashToken(token), time.Now().Add(passwordResetDuration), time.Now())
+	if err != nil {
+		log.Printf("[auth][password] failed to store reset token: %v", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("[auth][password] failed to store reset token: %v", err)
+		return
+	}
+
+	link := appLink("/reset-password?token=" + url.QueryEscape(token))
+	body := fmt.Sprintf("Someone requested a password reset for your Xwing account.\n\nOpen this link within %d minutes to choose a new password:\n%s\n\nIf it was not you, you can ignore this email.",
+		int(passwordResetDuration.Minutes()), link)
+	if err := mail.Send(accountEmail, "Reset your Xwing password", body); err != nil {
+		log.Printf("[auth][password] failed to send reset email: %v", err)
+	}
+}
+
+type resetPasswordReq struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=8"`
+}
+
+// PostResetPassword sets a new password with a reset token and revokes every session of the user
+func PostResetPassword(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var resetReq resetPasswordReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&resetReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to reset password!", err.Error())
+			return
+		}
+
+		newHash, err := bcrypt.GenerateFromPassword([]byte(resetReq.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to hash password!", err.Error())
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		// consume the token, a second attempt with the same token finds no row
+		var userID int
+		err = tx.QueryRow(`
+			UPDATE swordfish.password_resets
+			SET used_at = $1
+			WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1
+			RETURNING user_id
+		`, time.Now(), utils.HashToken(resetReq.Token)).Scan(&userID)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid or expired reset token", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if _, err := tx.Exec(`UPDATE swordfish.users SET password = $1, updated_at = $2 WHERE id = $3`, string(newHash), time.Now(), userID); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to update password!", err.Error())
+			return
+		}
+		if err := revokeUserSessions(tx, userID); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success reset password!",
+		})
+	}
+}
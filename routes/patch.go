@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/utils"
+)
+
+// entityTag is the ETag of a resource version. updated_at changes on every write
+// and is stored with microsecond precision, so it identifies the version.
+func entityTag(updatedAt time.Time) string {
+	return fmt.Sprintf(`"%d"`, updatedAt.UnixMicro())
+}
+
+// checkIfMatch compares the If-Match header with the current version of the resource and responds
+// with 412 and returns false when the resource was changed since the client read it.
+// Requests without If-Match are applied unconditionally.
+func checkIfMatch(c *gin.Context, updatedAt time.Time) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+
+	current := entityTag(updatedAt)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+
+	c.Header("ETag", current)
+	utils.RespondError(c, http.StatusPreconditionFailed, "Resource was modified by another request", "fetch it again and retry with the new ETag")
+	return false
+}
+
+// mergePatch applies a JSON Merge Patch (RFC 7396) to target, a pointer to a flat request struct.
+// Members set to null are reset to their zero value, members target does not have are rejected.
+func mergePatch(target any, patch []byte) error {
+	var changes map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &changes); err != nil || changes == nil {
+		return errors.New("body must be a JSON object")
+	}
+
+	raw, err := json.Marshal(target)
+	if err != nil {
+		return err
+	}
+	var document map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &document); err != nil {
+		return err
+	}
+
+	for name, value := range changes {
+		if _, ok := document[name]; !ok {
+			return fmt.Errorf("unknown field %q", name)
+		}
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			delete(document, name)
+		} else {
+			document[name] = value
+		}
+	}
+
+	raw, err = json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	value := reflect.ValueOf(target).Elem()
+	value.Set(reflect.Zero(value.Type()))
+	return json.Unmarshal(raw, target)
+}
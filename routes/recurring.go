@@ -0,0 +1,337 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+)
+
+type recurringRuleReq struct {
+	Type      string `json:"type" binding:"required,oneof=inflow outflow"`
+	Amount    int    `json:"amount" binding:"required"`
+	Category  string `json:"category" binding:"required"`
+	Notes     string `json:"notes"`
+	Frequency string `json:"frequency" binding:"required,oneof=daily weekly monthly yearly"`
+	Interval  int    `json:"interval" binding:"omitempty,min=1"`
+	StartDate string `json:"start_date" binding:"required,datetime=2006-01-02"`
+	EndDate   string `json:"end_date" binding:"omitempty,datetime=2006-01-02"`
+	Count     *int   `json:"count" binding:"omitempty,min=1"`
+}
+
+// parse converts the request dates and fills the defaults of a rule
+func (r *recurringRuleReq) parse() (start time.Time, end *time.Time, err error) {
+	if r.Interval == 0 {
+		r.Interval = 1
+	}
+	start, err = time.Parse("2006-01-02", r.StartDate)
+	if err != nil {
+		return start, nil, err
+	}
+	if r.EndDate != "" {
+		parsed, err := time.Parse("2006-01-02", r.EndDate)
+		if err != nil {
+			return start, nil, err
+		}
+		end = &parsed
+	}
+	return start, end, nil
+}
+
+const recurringRuleColumns = `id, user_id, type, amount, category, COALESCE(notes, '') as notes, frequency, repeat_interval,
+	start_date, end_date, count, occurrences, next_date, is_active, created_at, updated_at`
+
+func scanRecurringRule(row interface{ Scan(...any) error }, rule *models.RecurringRuleSchema) error {
+	return row.Scan(
+		&rule.ID,
+		&rule.UserId,
+		&rule.Type,
+		&rule.Amount,
+		&rule.Category,
+		&rule.Notes,
+		&rule.Frequency,
+		&rule.Interval,
+		&rule.StartDate,
+		&rule.EndDate,
+		&rule.Count,
+		&rule.Occurrences,
+		&rule.NextDate,
+		&rule.IsActive,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+}
+
+func GetRecurringRules(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rules := []models.RecurringRuleSchema{}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		query := `
+			SELECT ` + recurringRuleColumns + `
+			FROM swordfish.recurring_rules
+			WHERE ledger_id = $1 AND is_active = true
+			ORDER BY next_date ASC NULLS LAST, id ASC
+		`
+
+		rows, err := db.Query(query, ledgerID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch recurring rules!", err.Error())
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var rule models.RecurringRuleSchema
+			if err := scanRecurringRule(rows, &rule); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse recurring rule data!", err.Error())
+				return
+			}
+			rules = append(rules, rule)
+		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over recurring rules!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    rules,
+		})
+	}
+}
+
+func GetRecurringRuleById(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		query := `
+			SELECT ` + recurringRuleColumns + `
+			FROM swordfish.recurring_rules
+			WHERE ledger_id = $1 AND id = $2 AND is_active = true
+		`
+
+		var rule models.RecurringRuleSchema
+		err := scanRecurringRule(db.QueryRow(query, ledgerID, id), &rule)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Recurring rule not found", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    rule,
+		})
+	}
+}
+
+func PostCreateRecurringRule(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ruleReq recurringRuleReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&ruleReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to create recurring rule!", err.Error())
+			return
+		}
+		startDate, endDate, err := ruleReq.parse()
+		if err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to create recurring rule!", err.Error())
+			return
+		}
+		if endDate != nil && endDate.Before(startDate) {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to create recurring rule!", "end_date must not be before start_date")
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		// the first occurrence is always the start date, the scheduler picks it up once it is due
+		nextDate := utils.NextOccurrence(startDate, ruleReq.Frequency, ruleReq.Interval, 0, endDate, ruleReq.Count)
+
+		query := `
+			INSERT INTO swordfish.recurring_rules (ledger_id, user_id, type, amount, category, notes, frequency, repeat_interval,
+				start_date, end_date, count, next_date, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+			RETURNING ` + recurringRuleColumns
+
+		var newRule models.RecurringRuleSchema
+		err = scanRecurringRule(
+			db.QueryRow(query, ledgerID, userID, ruleReq.Type, ruleReq.Amount, ruleReq.Category, ruleReq.Notes, ruleReq.Frequency, ruleReq.Interval,
+				startDate, endDate, ruleReq.Count, nextDate, time.Now(), time.Now()),
+			&newRule,
+		)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to insert recurring rule into database!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success create recurring rule!",
+			"data":    newRule,
+		})
+	}
+}
+
+func PutUpdateRecurringRule(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ruleReq recurringRuleReq
+
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&ruleReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update recurring rule!", err.Error())
+			return
+		}
+		startDate, endDate, err := ruleReq.parse()
+		if err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update recurring rule!", err.Error())
+			return
+		}
+		if endDate != nil && endDate.Before(startDate) {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update recurring rule!", "end_date must not be before start_date")
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		// lock the rule so the scheduler does not materialize it while the schedule changes
+		var occurrences, oldInterval int
+		var oldStartDate time.Time
+		var oldFrequency string
+		err = tx.QueryRow(`
+			SELECT occurrences, start_date, frequency, repeat_interval
+			FROM swordfish.recurring_rules
+			WHERE id = $1 AND ledger_id = $2 AND is_active = true
+			FOR UPDATE
+		`, id, ledgerID).Scan(&occurrences, &oldStartDate, &oldFrequency, &oldInterval)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Recurring rule not found", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		// already materialized occurrences are kept. When the schedule itself changes it restarts
+		// from the new start date, only occurrences generated on or after it count, and the next
+		// run never falls on or before the last generated one.
+		var lastGenerated *time.Time
+		if oldStartDate.Format("2006-01-02") != startDate.Format("2006-01-02") || oldFrequency != ruleReq.Frequency || oldInterval != ruleReq.Interval {
+			err = tx.QueryRow(`
+				SELECT COUNT(*), MAX(date)
+				FROM swordfish.transactions
+				WHERE recurring_rule_id = $1 AND date >= $2
+			`, id, startDate.Format("2006-01-02")).Scan(&occurrences, &lastGenerated)
+			if err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+				return
+			}
+		}
+		nextDate := utils.NextOccurrence(startDate, ruleReq.Frequency, ruleReq.Interval, occurrences, endDate, ruleReq.Count)
+		for lastGenerated != nil && nextDate != nil && !nextDate.After(*lastGenerated) {
+			occurrences++
+			nextDate = utils.NextOccurrence(startDate, ruleReq.Frequency, ruleReq.Interval, occurrences, endDate, ruleReq.Count)
+		}
+
+		query := `
+			UPDATE swordfish.recurring_rules
+			SET type = $1, amount = $2, category = $3, notes = $4, frequency = $5, repeat_interval = $6,
+				start_date = $7, end_date = $8, count = $9, occurrences = $10, next_date = $11, updated_at = $12
+			WHERE id = $13 AND ledger_id = $14
+			RETURNING ` + recurringRuleColumns
+
+		var updatedRule models.RecurringRuleSchema
+		err = scanRecurringRule(
+			tx.QueryRow(query, ruleReq.Type, ruleReq.Amount, ruleReq.Category, ruleReq.Notes, ruleReq.Frequency, ruleReq.Interval,
+				startDate, endDate, ruleReq.Count, occurrences, nextDate, time.Now(), id, ledgerID),
+			&updatedRule,
+		)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success update recurring rule!",
+			"data":    updatedRule,
+		})
+	}
+}
+
+func DeleteRecurringRule(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		// transactions that were already created by the rule are kept
+		query := `
+			UPDATE swordfish.recurring_rules
+			SET is_active = false, next_date = NULL, updated_at = $1
+			WHERE id = $2 AND ledger_id = $3 AND is_active = true
+		`
+		result, err := db.Exec(query, time.Now(), id, ledgerID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error checking update result", err.Error())
+			return
+		}
+		if rowsAffected == 0 {
+			utils.RespondError(c, http.StatusNotFound, "Recurring rule not found or already inactive", "")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Recurring rule deleted successfully",
+		})
+	}
+}
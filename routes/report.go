@@ -4,15 +4,19 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/halosatrio/xwing/utils"
+	"github.com/lib/pq"
 )
 
 type quarterQueryReq struct {
+	transactionFilterReq
+	exportReq
 	Year string `form:"year" binding:"required"`
 	Q    string `form:"q" binding:"required"`
 }
@@ -80,31 +84,20 @@ func checkCategory(resQuery []Transaction, categories []string) []Transaction {
 	return append(resQuery, missingItems...)
 }
 
-func getQuarterQuery(db *sql.DB, userID float64, date1, date2 string, category string) ([]Transaction, error) {
-	var args []interface{}
-	args = append(args, userID, date1, date2)
+func getQuarterQuery(db *sql.DB, ledgerID int, date1, date2 string, categories []string, filter transactionFilterReq) ([]Transaction, error) {
+	qb := newTransactionQuery(ledgerID)
+	qb.where("date BETWEEN ? AND ?", date1, date2)
+	qb.where("category = ANY(?)", pq.Array(categories))
+	filter.apply(qb)
 
 	query := `
 		SELECT category, SUM(amount) as amount
 		FROM swordfish.transactions as tx
-		WHERE tx.user_id = $1 
-			AND tx.is_active = true 
-			AND tx.date BETWEEN $2 AND $3
+		WHERE ` + qb.sql() + `
+		GROUP BY category
 	`
 
-	// this might be a dumb fixing, but let's see
-	if category == "ESSENTIALS" {
-		query += ` AND tx.category IN ('makan', 'cafe', 'utils', 'errand', 'bensin', 'olahraga')`
-	} else if category == "NON-ESSENTIALS" {
-		query += ` AND tx.category IN ('misc', 'family', 'transport', 'traveling', 'healthcare', 'date')`
-	} else if category == "SHOPPING" {
-		query += ` AND tx.category IN ('belanja')`
-	}
-
-	query += " GROUP BY category"
-
-	// log.Print(query)
-	rows, err := db.Query(query, args...)
+	rows, err := db.Query(query, qb.params()...)
 	if err != nil {
 		return nil, err
 	}
@@ -118,33 +111,24 @@ func getQuarterQuery(db *sql.DB, userID float64, date1, date2 string, category s
 		}
 		result = append(result, transaction)
 	}
-	// log.Print(result)
-	return result, nil
+	return result, rows.Err()
 }
 
-func GetQuarterEssentials(db *sql.DB) gin.HandlerFunc {
+// GetQuarterGroup returns the per-month spending of a quarter for every category
+// in the :group category group, e.g. /report/quarter/essentials
+func GetQuarterGroup(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var queryReq quarterQueryReq
 
 		// Bind query parameters
 		if err := c.BindQuery(&queryReq); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid query parameters!",
-				"errors":  err.Error(),
-			})
+			utils.RespondError(c, http.StatusBadRequest, "Invalid query parameters!", err.Error())
 			return
 		}
 
-		// userID, ok := c.MustGet("user_id").(float64)
-		userID, ok := c.MustGet("user_id").(float64)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized user",
-			})
-			return
-		}
+		// get userid jwt and ledger of the request
+		userID, _ := c.MustGet("user_id").(float64)
+		ledgerID, _ := c.MustGet("ledger_id").(int)
 
 		if _, err := strconv.Atoi(queryReq.Year); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"status": 400, "message": "Year must be a number"})
@@ -155,82 +139,16 @@ func GetQuarterEssentials(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		essentials := []string{"makan", "cafe", "utils", "errand", "bensin", "olahraga"}
-
-		// Define date ranges for the quarter
-		months := [][]string{}
-		for i := 0; i < 3; i++ {
-			start, err := getFirstDate(queryReq.Year, queryReq.Q, i)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"status": 400, "message": err.Error()})
-				return
-			}
-			end, err := getLastDate(queryReq.Year, queryReq.Q, i)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"status": 400, "message": err.Error()})
-				return
-			}
-			months = append(months, []string{start, end})
-		}
-
-		var results [][]Transaction
-		for _, month := range months {
-			res, err := getQuarterQuery(db, userID, month[0], month[1], "ESSENTIALS")
-			if err != nil {
-				log.Printf("Error fetching query: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"status": 500, "message": "Error fetching data"})
-				return
-			}
-			results = append(results, checkCategory(res, essentials))
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"status":  200,
-			"message": "Success!",
-			"data": gin.H{
-				"month1": results[0],
-				"month2": results[1],
-				"month3": results[2],
-			},
-		})
-	}
-}
-
-func GetQuarterNonEssentials(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var queryReq quarterQueryReq
-
-		// Bind query parameters
-		if err := c.BindQuery(&queryReq); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid query parameters!",
-				"errors":  err.Error(),
-			})
+		categories, ok, err := getGroupCategories(db, userID, c.Param("group"))
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch category group!", err.Error())
 			return
 		}
-
-		// get userid jwt
-		userID, ok := c.MustGet("user_id").(float64)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized user",
-			})
+			utils.RespondError(c, http.StatusNotFound, "Category group not found", "")
 			return
 		}
 
-		if _, err := strconv.Atoi(queryReq.Year); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"status": 400, "message": "Year must be a number"})
-			return
-		}
-		if _, err := strconv.Atoi(queryReq.Q); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"status": 400, "message": "Quarter must be a number"})
-			return
-		}
-
-		nonEssentials := []string{"misc", "family", "transport", "traveling", "healthcare", "date"}
-
 		// Define date ranges for the quarter
 		months := [][]string{}
 		for i := 0; i < 3; i++ {
@@ -249,87 +167,28 @@ func GetQuarterNonEssentials(db *sql.DB) gin.HandlerFunc {
 
 		var results [][]Transaction
 		for _, month := range months {
-			res, err := getQuarterQuery(db, userID, month[0], month[1], "NON-ESSENTIALS")
+			res, err := getQuarterQuery(db, ledgerID, month[0], month[1], categories, queryReq.transactionFilterReq)
 			if err != nil {
 				log.Printf("Error fetching query: %v", err)
 				c.JSON(http.StatusInternalServerError, gin.H{"status": 500, "message": "Error fetching data"})
 				return
 			}
-			results = append(results, checkCategory(res, nonEssentials))
-		}
-
-		c.JSON(http.StatusOK, gin.H{
-			"status":  200,
-			"message": "Success!",
-			"data": gin.H{
-				"month1": results[0],
-				"month2": results[1],
-				"month3": results[2],
-			},
-		})
-	}
-}
-
-func GetQuarterShopping(db *sql.DB) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var queryReq quarterQueryReq
-
-		// Bind query parameters
-		if err := c.BindQuery(&queryReq); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid query parameters!",
-				"errors":  err.Error(),
-			})
-			return
-		}
-
-		// userID, ok := c.MustGet("user_id").(float64)
-		userID, ok := c.MustGet("user_id").(float64)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized user",
-			})
-			return
-		}
-
-		if _, err := strconv.Atoi(queryReq.Year); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"status": 400, "message": "Year must be a number"})
-			return
-		}
-		if _, err := strconv.Atoi(queryReq.Q); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"status": 400, "message": "Quarter must be a number"})
-			return
-		}
-
-		shopping := []string{"belanja"}
-
-		// Define date ranges for the quarter
-		months := [][]string{}
-		for i := 0; i < 3; i++ {
-			start, err := getFirstDate(queryReq.Year, queryReq.Q, i)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"status": 400, "message": err.Error()})
-				return
-			}
-			end, err := getLastDate(queryReq.Year, queryReq.Q, i)
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"status": 400, "message": err.Error()})
-				return
-			}
-			months = append(months, []string{start, end})
-		}
-
-		var results [][]Transaction
-		for _, month := range months {
-			res, err := getQuarterQuery(db, userID, month[0], month[1], "SHOPPING")
-			if err != nil {
-				log.Printf("Error fetching query: %v", err)
-				c.JSON(http.StatusInternalServerError, gin.H{"status": 500, "message": "Error fetching data"})
-				return
+			results = append(results, checkCategory(res, categories))
+		}
+
+		if queryReq.isExport() {
+			filename := fmt.Sprintf("quarter-%s-%s-q%s", c.Param("group"), queryReq.Year, queryReq.Q)
+			w := newExportWriter(c, queryReq.Format, filename)
+			err := w.WriteSheet("quarter", "month", "category", "amount")
+			for i, result := range results {
+				for _, tx := range result {
+					if err == nil {
+						err = w.WriteRow(months[i][0][:7], tx.Category, tx.Amount)
+					}
+				}
 			}
-			results = append(results, checkCategory(res, shopping))
+			closeExport(c, w, err)
+			return
 		}
 
 		c.JSON(http.StatusOK, gin.H{
@@ -345,6 +204,8 @@ func GetQuarterShopping(db *sql.DB) gin.HandlerFunc {
 }
 
 type AnnualQueryReq struct {
+	transactionFilterReq
+	exportReq
 	Year string `form:"year" binding:"required"`
 }
 
@@ -375,8 +236,16 @@ func GetAnnualCashflow(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// get userid jwt
-		userID, _ := c.MustGet("user_id").(float64)
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		// Use year from query to define the range
+		startDate := fmt.Sprintf("%s-01-01", queryReq.Year)
+		endDate := fmt.Sprintf("%s-12-31", queryReq.Year)
+
+		qb := newTransactionQuery(ledgerID)
+		qb.where("date BETWEEN ? AND ?", startDate, endDate)
+		queryReq.apply(qb)
 
 		queryMonthly := `
       SELECT
@@ -386,19 +255,12 @@ func GetAnnualCashflow(db *sql.DB) gin.HandlerFunc {
 				cast(SUM(CASE WHEN type = 'inflow' THEN amount ELSE 0 END) - SUM(CASE WHEN type = 'outflow' THEN amount ELSE 0 END) as int) AS saving
 			FROM
 				swordfish.transactions AS tx
-			WHERE
-				tx.user_id = $1 AND
-				tx.is_active = true
-				AND tx.date BETWEEN $2 AND $3
+			WHERE ` + qb.sql() + `
 			GROUP BY month
 			ORDER BY month
     `
 
-		// Use year from query to define the range
-		startDate := fmt.Sprintf("%s-01-01", queryReq.Year)
-		endDate := fmt.Sprintf("%s-12-31", queryReq.Year)
-
-		rows, err := db.Query(queryMonthly, userID, startDate, endDate)
+		rows, err := db.Query(queryMonthly, qb.params()...)
 		if err != nil {
 			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch data!", err.Error())
 			return
@@ -416,6 +278,10 @@ func GetAnnualCashflow(db *sql.DB) gin.HandlerFunc {
 			}
 			monthlyMap[annualReportData.Month] = annualReportData
 		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over data!", err.Error())
+			return
+		}
 
 		// Generate default data for all 12 months
 		var resultMonthly []AnnualReport
@@ -435,19 +301,16 @@ func GetAnnualCashflow(db *sql.DB) gin.HandlerFunc {
 
 		queryAnnual := `
       SELECT
-        CAST(SUM(CASE WHEN type = 'inflow' THEN amount else 0 END) as int) AS total_inflow,
-        CAST(SUM(CASE WHEN type = 'outflow' THEN amount else 0 END) as int) AS total_outflow,
-        CAST(SUM(CASE WHEN type = 'inflow' THEN amount else 0 END) - SUM(CASE WHEN type = 'outflow' THEN amount else 0 END) as int) AS total_saving
+        CAST(COALESCE(SUM(CASE WHEN type = 'inflow' THEN amount else 0 END), 0) as int) AS total_inflow,
+        CAST(COALESCE(SUM(CASE WHEN type = 'outflow' THEN amount else 0 END), 0) as int) AS total_outflow,
+        CAST(COALESCE(SUM(CASE WHEN type = 'inflow' THEN amount else 0 END) - SUM(CASE WHEN type = 'outflow' THEN amount else 0 END), 0) as int) AS total_saving
       FROM 
         swordfish.transactions AS tx
-			WHERE
-				tx.user_id = $1 AND
-				tx.is_active = true
-				AND tx.date BETWEEN $2 AND $3
+			WHERE ` + qb.sql() + `
     `
 
 		var resultAnnual AnnualCasflow
-		err = db.QueryRow(queryAnnual, userID, startDate, endDate).
+		err = db.QueryRow(queryAnnual, qb.params()...).
 			Scan(
 				&resultAnnual.TotalInflow,
 				&resultAnnual.TotalOutflow,
@@ -458,6 +321,24 @@ func GetAnnualCashflow(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		if queryReq.isExport() {
+			w := newExportWriter(c, queryReq.Format, "annual-cashflow-"+queryReq.Year)
+			err := w.WriteSheet("monthly", "month", "inflow", "outflow", "saving")
+			for _, report := range resultMonthly {
+				if err == nil {
+					err = w.WriteRow(report.Month, report.Inflow, report.Outflow, report.Saving)
+				}
+			}
+			if err == nil {
+				err = w.WriteSheet("total", "total_inflow", "total_outflow", "total_saving")
+			}
+			if err == nil {
+				err = w.WriteRow(resultAnnual.TotalInflow, resultAnnual.TotalOutflow, resultAnnual.TotalSaving)
+			}
+			closeExport(c, w, err)
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"status":  200,
 			"message": "Success!",
@@ -469,7 +350,20 @@ func GetAnnualCashflow(db *sql.DB) gin.HandlerFunc {
 	}
 }
 
-// WIP, this is for all months per caetgory (GET Annual
+type AnnualCategoryMonth struct {
+	Month      int           `json:"month"`
+	Categories []Transaction `json:"categories"`
+}
+
+type AnnualCategoryTotal struct {
+	Category string  `json:"category"`
+	Total    int     `json:"total"`
+	Average  float64 `json:"average"`
+	Share    float64 `json:"share"`
+}
+
+// GetAnnualReport returns the outflow of every category for each month of the year,
+// along with the per-category total, monthly average and share of the annual outflow
 func GetAnnualReport(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var queryReq AnnualQueryReq
@@ -485,86 +379,119 @@ func GetAnnualReport(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		// get userid jwt
-		userID, _ := c.MustGet("user_id").(float64)
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		// Use year from query to define the range
+		startDate := fmt.Sprintf("%s-01-01", queryReq.Year)
+		endDate := fmt.Sprintf("%s-12-31", queryReq.Year)
+
+		qb := newTransactionQuery(ledgerID)
+		qb.where("type = 'outflow'")
+		qb.where("date BETWEEN ? AND ?", startDate, endDate)
+		queryReq.apply(qb)
 
 		queryMonthly := `
 			SELECT
+				CAST(EXTRACT(MONTH FROM date) AS int) AS month,
 				category,
-        CAST(SUM(amount) AS int) AS amount
+				CAST(SUM(amount) AS int) AS amount
 			FROM
 				swordfish.transactions AS tx
-			WHERE
-				tx.user_id = $1 AND
-				tx.is_active = true
-				AND tx.date BETWEEN '2024-01-01' AND '2024-12-31'
-			GROUP BY category
+			WHERE ` + qb.sql() + `
+			GROUP BY month, category
+			ORDER BY month, category
 		`
 
-		rows, err := db.Query(queryMonthly, userID)
+		rows, err := db.Query(queryMonthly, qb.params()...)
 		if err != nil {
 			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch data!", err.Error())
 			return
 		}
 		defer rows.Close()
 
-		var resultMontly []AnnualReport
+		// Map for storing results by month, categories in order of first appearance
+		monthlyMap := make(map[int][]Transaction)
+		categoryTotals := make(map[string]int)
+		var categories []string
+		totalOutflow := 0
 		for rows.Next() {
-			var annualReportData AnnualReport
-			err := rows.Scan(&annualReportData.Month, &annualReportData.Inflow, &annualReportData.Outflow, &annualReportData.Saving)
-			if err != nil {
+			var month int
+			var tx Transaction
+			if err := rows.Scan(&month, &tx.Category, &tx.Amount); err != nil {
 				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse data!", err.Error())
 				return
 			}
-			resultMontly = append(resultMontly, annualReportData)
+			if _, exists := categoryTotals[tx.Category]; !exists {
+				categories = append(categories, tx.Category)
+			}
+			monthlyMap[month] = append(monthlyMap[month], tx)
+			categoryTotals[tx.Category] += tx.Amount
+			totalOutflow += tx.Amount
+		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over data!", err.Error())
+			return
 		}
-		log.Print(resultMontly)
 
-		queryAnnual := `
-			SELECT
-				SUM(CASE WHEN type = 'outflow' THEN amount ELSE 0 END) AS outflow,
-				SUM(CASE WHEN type = 'inflow' THEN amount ELSE 0 END) AS inflow,
-				SUM(CASE WHEN type = 'inflow' THEN amount ELSE 0 END) - 
-				SUM(CASE WHEN type = 'outflow' THEN amount ELSE 0 END) AS saving
-			FROM swordfish.transactions AS tx
-			WHERE tx.user_id = $1 
-			AND tx.is_active = TRUE 
-			AND tx.date BETWEEN '2024-01-01' AND '2024-12-31';
-		`
-		rowsAnnual, err := db.Query(queryAnnual, userID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status":  http.StatusInternalServerError,
-				"message": "Failed to fetch data!",
-				"error":   err.Error(),
+		// Generate data for all 12 months, every month listing every category
+		resultMonthly := []AnnualCategoryMonth{}
+		for month := 1; month <= 12; month++ {
+			monthCategories := checkCategory(monthlyMap[month], categories)
+			if monthCategories == nil {
+				monthCategories = []Transaction{}
+			}
+			resultMonthly = append(resultMonthly, AnnualCategoryMonth{
+				Month:      month,
+				Categories: monthCategories,
 			})
-			return
 		}
-		defer rowsAnnual.Close()
 
-		var resultAnnual []AnnualReport
-		for rowsAnnual.Next() {
-			var annualReportData AnnualReport
-			err := rowsAnnual.Scan(&annualReportData.Inflow, &annualReportData.Outflow, &annualReportData.Saving)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{
-					"status":  http.StatusInternalServerError,
-					"message": "Failed to parse data!",
-					"error":   err.Error(),
-				})
-				return
+		resultCategories := []AnnualCategoryTotal{}
+		for _, category := range categories {
+			total := categoryTotals[category]
+			share := 0.0
+			if totalOutflow > 0 {
+				share = math.Round(float64(total)/float64(totalOutflow)*10000) / 100
 			}
-			resultAnnual = append(resultAnnual, annualReportData)
+			resultCategories = append(resultCategories, AnnualCategoryTotal{
+				Category: category,
+				Total:    total,
+				Average:  math.Round(float64(total)/12*100) / 100,
+				Share:    share,
+			})
+		}
+
+		if queryReq.isExport() {
+			w := newExportWriter(c, queryReq.Format, "annual-report-"+queryReq.Year)
+			err := w.WriteSheet("monthly", "month", "category", "amount")
+			for _, month := range resultMonthly {
+				for _, tx := range month.Categories {
+					if err == nil {
+						err = w.WriteRow(month.Month, tx.Category, tx.Amount)
+					}
+				}
+			}
+			if err == nil {
+				err = w.WriteSheet("categories", "category", "total", "average", "share")
+			}
+			for _, total := range resultCategories {
+				if err == nil {
+					err = w.WriteRow(total.Category, total.Total, total.Average, total.Share)
+				}
+			}
+			closeExport(c, w, err)
+			return
 		}
-		log.Print(resultAnnual)
 
 		// success response
 		c.JSON(http.StatusOK, gin.H{
 			"status":  http.StatusOK,
-			"message": "Success",
+			"message": "Success!",
 			"data": gin.H{
-				"monthly": resultMontly,
-				"total":   resultAnnual,
+				"monthly":       resultMonthly,
+				"categories":    resultCategories,
+				"total_outflow": totalOutflow,
 			},
 		})
 	}
@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetAnnualCashflowFilterMatchesNothing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	router := gin.New()
+	router.GET("/v1/report/annual/cashflow", func(c *gin.Context) { c.Set("ledger_id", 3) }, GetAnnualCashflow(db))
+
+	mock.ExpectQuery("GROUP BY month").
+		WithArgs(3, "2024-01-01", "2024-12-31", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"month", "inflow", "outflow", "saving"}))
+	// the totals of no rows are NULL without COALESCE
+	mock.ExpectQuery(`COALESCE\(SUM\(CASE WHEN type = 'inflow'.*COALESCE\(SUM\(CASE WHEN type = 'outflow'.*COALESCE\(`).
+		WithArgs(3, "2024-01-01", "2024-12-31", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"total_inflow", "total_outflow", "total_saving"}).AddRow(0, 0, 0))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/report/annual/cashflow?year=2024&category=nothing", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var body struct {
+		Data struct {
+			Monthly []AnnualReport `json:"monthly"`
+			Total   AnnualCasflow  `json:"total"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Data.Monthly) != 12 {
+		t.Fatalf("got %d months, want 12", len(body.Data.Monthly))
+	}
+	for _, month := range body.Data.Monthly {
+		if month.Inflow != 0 || month.Outflow != 0 || month.Saving != 0 {
+			t.Fatalf("month %d = %+v, want zeros", month.Month, month)
+		}
+	}
+	if body.Data.Total != (AnnualCasflow{}) {
+		t.Fatalf("total = %+v, want zeros", body.Data.Total)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
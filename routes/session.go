@@ -0,0 +1,240 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/utils"
+)
+
+type authTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int
+}
+
+// createRefreshToken stores a new refresh token of the session and returns the plain token
+func createRefreshToken(tx *sql.Tx, sessionID string, expiresAt time.Time) (string, error) {
+	refreshToken, err := utils.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(`
+		INSERT INTO swordfish.refresh_tokens (session_id, token_hash, expires_at, created_at)
+		VALUES ($1, $2, $3, $4)
+	`, sessionID, utils.HashToken(refreshToken), expiresAt, time.Now())
+	return refreshToken, err
+}
+
+// issueTokens starts a new session for the user and returns its access and refresh tokens
+func issueTokens(db *sql.DB, c *gin.Context, userID int, email string) (authTokens, error) {
+	var tokens authTokens
+
+	sessionID, err := utils.RandomToken(24)
+	if err != nil {
+		return tokens, err
+	}
+	expiresAt := time.Now().Add(utils.RefreshTokenDuration())
+
+	tx, err := db.Begin()
+	if err != nil {
+		return tokens, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO swordfish.sessions (id, user_id, expires_at, user_agent, ip, created_at, last_used_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, sessionID, userID, expiresAt, c.Request.UserAgent(), c.ClientIP(), time.Now(), time.Now())
+	if err != nil {
+		return tokens, err
+	}
+
+	refreshToken, err := createRefreshToken(tx, sessionID, expiresAt)
+	if err != nil {
+		return tokens, err
+	}
+
+	accessToken, err := utils.GenerateAccessToken(userID, email, sessionID)
+	if err != nil {
+		return tokens, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return tokens, err
+	}
+
+	return authTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(utils.AccessTokenDuration().Seconds()),
+	}, nil
+}
+
+// respondTokens keeps the access token in data, as returned by login before refresh tokens existed
+func respondTokens(c *gin.Context, message string, tokens authTokens) {
+	c.JSON(http.StatusOK, gin.H{
+		"status":        http.StatusOK,
+		"message":       message,
+		"data":          tokens.AccessToken,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+	})
+}
+
+// revokeUserSessions revokes every active session of the user
+func revokeUserSessions(db execer, userID int) error {
+	_, err := db.Exec(`
+		UPDATE swordfish.sessions
+		SET revoked_at = $1
+		WHERE user_id = $2 AND revoked_at IS NULL
+	`, time.Now(), userID)
+	return err
+}
+
+type refreshTokenReq struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// RefreshToken exchanges a refresh token for a new access token and a new refresh token.
+// Presenting a refresh token that was already used revokes the whole session,
+// since it means the token was stolen or replayed.
+func RefreshToken(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var refreshReq refreshTokenReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&refreshReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to refresh token!", err.Error())
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		var (
+			tokenID   int
+			sessionID string
+			userID    int
+			email     string
+			usedAt    *time.Time
+			expiresAt time.Time
+			revoked   bool
+		)
+		err = tx.QueryRow(`
+			SELECT rt.id, s.id, u.id, u.email, rt.used_at, s.expires_at, s.revoked_at IS NOT NULL
+			FROM swordfish.refresh_tokens AS rt
+			JOIN swordfish.sessions AS s ON s.id = rt.session_id
+			JOIN swordfish.users AS u ON u.id = s.user_id
+			WHERE rt.token_hash = $1
+			FOR UPDATE OF rt, s
+		`, utils.HashToken(refreshReq.RefreshToken)).
+			Scan(&tokenID, &sessionID, &userID, &email, &usedAt, &expiresAt, &revoked)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusUnauthorized, "Invalid refresh token", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if usedAt != nil {
+			// reuse of a rotated token, revoke the session
+			if _, err := tx.Exec(`UPDATE swordfish.sessions SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL`, time.Now(), sessionID); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+				return
+			}
+			utils.RespondError(c, http.StatusUnauthorized, "Invalid refresh token", "refresh token has already been used, session revoked")
+			return
+		}
+		if revoked || time.Now().After(expiresAt) {
+			utils.RespondError(c, http.StatusUnauthorized, "Invalid refresh token", "session has expired or was revoked")
+			return
+		}
+
+		// rotate the refresh token
+		if _, err := tx.Exec(`UPDATE swordfish.refresh_tokens SET used_at = $1 WHERE id = $2`, time.Now(), tokenID); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if _, err := tx.Exec(`UPDATE swordfish.sessions SET last_used_at = $1 WHERE id = $2`, time.Now(), sessionID); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		refreshToken, err := createRefreshToken(tx, sessionID, expiresAt)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to generate refresh token", err.Error())
+			return
+		}
+
+		accessToken, err := utils.GenerateAccessToken(userID, email, sessionID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to generate token", "[auth][jwt]"+err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		respondTokens(c, "Success refresh token!", authTokens{
+			AccessToken:  accessToken,
+			RefreshToken: refreshToken,
+			ExpiresIn:    int(utils.AccessTokenDuration().Seconds()),
+		})
+	}
+}
+
+type logoutReq struct {
+	All bool `json:"all"`
+}
+
+// LogoutUser revokes the current session, or every session of the user when all is true
+func LogoutUser(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var logoutReq logoutReq
+
+		// body is optional
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&logoutReq); err != nil {
+				utils.RespondError(c, http.StatusBadRequest, "Failed to logout!", err.Error())
+				return
+			}
+		}
+
+		// get userid and session jwt
+		userID, _ := c.MustGet("user_id").(float64)
+		sessionID, _ := c.MustGet("session_id").(string)
+
+		var err error
+		if logoutReq.All {
+			err = revokeUserSessions(db, int(userID))
+		} else {
+			_, err = db.Exec(`
+				UPDATE swordfish.sessions
+				SET revoked_at = $1
+				WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL
+			`, time.Now(), sessionID, userID)
+		}
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success logout!",
+		})
+	}
+}
@@ -2,24 +2,65 @@ package routes
 
 import (
 	"database/sql"
-	"log"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/halosatrio/xwing/audit"
 	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
 )
 
 type transactionQueryReq struct {
-	DateStart string `form:"date_start"`
-	DateEnd   string `form:"date_end"`
-	Category  string `form:"category"`
+	transactionFilterReq
+	exportReq
+	Limit  int    `form:"limit" binding:"omitempty,min=1"`
+	Sort   string `form:"sort" binding:"omitempty,oneof=date amount created_at"`
+	Order  string `form:"order" binding:"omitempty,oneof=asc desc"`
+	Cursor string `form:"cursor"`
+}
+
+// transactionSortColumns are the columns GET /transaction can be sorted by
+var transactionSortColumns = map[string]sortColumn{
+	"date":       {column: "date", cast: "date"},
+	"amount":     {column: "amount", cast: "numeric"},
+	"created_at": {column: "created_at", cast: "timestamp"},
+}
+
+// transactionSortValue returns the value of the sort column as stored in a cursor
+func transactionSortValue(transaction models.TransactionSchema, sort string) string {
+	switch sort {
+	case "amount":
+		return transaction.Amount
+	case "created_at":
+		return transaction.CreatedAt.Format(time.RFC3339Nano)
+	default:
+		return transaction.Date.Format(time.RFC3339Nano)
+	}
+}
+
+func scanTransaction(row interface{ Scan(...any) error }, transaction *models.TransactionSchema) error {
+	return row.Scan(
+		&transaction.ID,
+		&transaction.UserId,
+		&transaction.Type,
+		&transaction.Amount,
+		&transaction.Category,
+		&transaction.Date,
+		&transaction.Notes,
+		&transaction.IsActive,
+		&transaction.CreatedAt,
+		&transaction.UpdatedAt,
+	)
 }
 
 func GetAllTransactions(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var transactions []models.TransactionSchema
+		transactions := []models.TransactionSchema{}
 		var queryReq transactionQueryReq
 
 		// Bind query parameters
@@ -32,43 +73,84 @@ func GetAllTransactions(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		userID, ok := c.MustGet("user_id").(float64)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized user",
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		// Pagination and sorting defaults
+		limit := queryReq.Limit
+		if limit == 0 {
+			limit = defaultPageLimit
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		if queryReq.Sort == "" {
+			queryReq.Sort = "date"
+		}
+		if queryReq.Order == "" {
+			queryReq.Order = "asc"
+		}
+		sortCol := transactionSortColumns[queryReq.Sort]
+
+		// Filters
+		qb := newTransactionQuery(ledgerID)
+		queryReq.apply(qb)
+
+		// Files contain every matching transaction, pagination only applies to JSON
+		if queryReq.isExport() {
+			exportTransactions(c, db, queryReq.Format, qb, sortCol.column+" "+strings.ToUpper(queryReq.Order))
+			return
+		}
+
+		// Total count ignores the cursor so it stays the same on every page
+		var total int
+		countQuery := "SELECT COUNT(*) FROM swordfish.transactions WHERE " + qb.sql()
+		if err := db.QueryRow(countQuery, qb.params()...).Scan(&total); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  http.StatusInternalServerError,
+				"message": "Failed to count transactions!",
+				"error":   err.Error(),
 			})
 			return
 		}
 
-		// Base query
+		// Continue after the last row of the previous page, id breaks ties
+		if queryReq.Cursor != "" {
+			cursor, err := decodeCursor(queryReq.Cursor)
+			if err != nil || cursor.Sort != queryReq.Sort || cursor.Order != queryReq.Order {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"status":  http.StatusBadRequest,
+					"message": "Invalid cursor!",
+					"errors":  "cursor does not match the requested sort",
+				})
+				return
+			}
+			if cursor.ID <= 0 || !sortCol.validCursorValue(cursor.Value) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"status":  http.StatusBadRequest,
+					"message": "Invalid cursor!",
+					"errors":  "cursor value does not match the sort column",
+				})
+				return
+			}
+			comparator := ">"
+			if queryReq.Order == "desc" {
+				comparator = "<"
+			}
+			qb.where(fmt.Sprintf("(%s, id) %s (?::%s, ?)", sortCol.column, comparator, sortCol.cast), cursor.Value, cursor.ID)
+		}
+
 		query := `
 			SELECT id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
 			FROM swordfish.transactions
-			WHERE user_id=$1 AND is_active=true
-		`
+			WHERE ` + qb.sql()
 
-		// Query parameters for filtering
-		var args []interface{}
-		args = append(args, userID)
-
-		if queryReq.DateStart != "" {
-			query += " AND date >= $2"
-			args = append(args, queryReq.DateStart)
-		}
-		if queryReq.DateEnd != "" {
-			query += " AND date <= $3"
-			args = append(args, queryReq.DateEnd)
-		}
-		if queryReq.Category != "" {
-			query += " AND category = $4"
-			args = append(args, queryReq.Category)
-		}
-
-		query += " ORDER BY date ASC LIMIT 200"
+		// fetch one extra row to know whether there is a next page
+		direction := strings.ToUpper(queryReq.Order)
+		query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %d", sortCol.column, direction, direction, limit+1)
 
 		// Execute query
-		rows, err := db.Query(query, args...)
+		rows, err := db.Query(query, qb.params()...)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  http.StatusInternalServerError,
@@ -82,19 +164,7 @@ func GetAllTransactions(db *sql.DB) gin.HandlerFunc {
 		// Scan rows
 		for rows.Next() {
 			var transaction models.TransactionSchema
-			err := rows.Scan(
-				&transaction.ID,
-				&transaction.UserId,
-				&transaction.Type,
-				&transaction.Amount,
-				&transaction.Category,
-				&transaction.Date,
-				&transaction.Notes,
-				&transaction.IsActive,
-				&transaction.CreatedAt,
-				&transaction.UpdatedAt,
-			)
-			if err != nil {
+			if err := scanTransaction(rows, &transaction); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{
 					"status":  http.StatusInternalServerError,
 					"message": "Failed to parse transaction data!",
@@ -115,15 +185,65 @@ func GetAllTransactions(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		var nextCursor *string
+		if len(transactions) > limit {
+			transactions = transactions[:limit]
+			last := transactions[limit-1]
+			token := encodeCursor(pageCursor{
+				Sort:  queryReq.Sort,
+				Order: queryReq.Order,
+				Value: transactionSortValue(last, queryReq.Sort),
+				ID:    last.ID,
+			})
+			nextCursor = &token
+		}
+
 		// Respond with success
 		c.JSON(http.StatusOK, gin.H{
 			"status":  http.StatusOK,
 			"message": "Success!",
 			"data":    transactions,
+			"pagination": gin.H{
+				"limit":       limit,
+				"total":       total,
+				"next_cursor": nextCursor,
+			},
 		})
 	}
 }
 
+// exportTransactions streams the transactions matching qb as a csv or xlsx file
+func exportTransactions(c *gin.Context, db *sql.DB, format string, qb *queryBuilder, orderBy string) {
+	query := `
+		SELECT id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
+		FROM swordfish.transactions
+		WHERE ` + qb.sql() + `
+		ORDER BY ` + orderBy + `, id`
+
+	rows, err := db.Query(query, qb.params()...)
+	if err != nil {
+		utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch transactions!", err.Error())
+		return
+	}
+	defer rows.Close()
+
+	w := newExportWriter(c, format, "transactions-"+time.Now().Format("20060102"))
+	err = w.WriteSheet("transactions", "id", "date", "type", "category", "amount", "notes", "created_at")
+	for err == nil && rows.Next() {
+		var transaction models.TransactionSchema
+		if err = scanTransaction(rows, &transaction); err != nil {
+			break
+		}
+		amount, _ := strconv.ParseFloat(transaction.Amount, 64)
+		err = w.WriteRow(transaction.ID, transaction.Date, transaction.Type, transaction.Category, amount, transaction.Notes,
+			transaction.CreatedAt.Format(time.RFC3339))
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	closeExport(c, w, err)
+}
+
 type transactionID struct {
 	ID string `uri:"id" binding:"required"`
 }
@@ -154,23 +274,17 @@ func GetTransactionById(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		userID, ok := c.MustGet("user_id").(float64)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized user",
-			})
-			return
-		}
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
 
 		// query
 		query := `
 			SELECT id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
 			FROM swordfish.transactions
-			WHERE user_id=$1 AND is_active=true AND id=$2
+			WHERE ledger_id=$1 AND is_active=true AND id=$2
 		`
 
-		err = db.QueryRow(query, userID, id).
+		err = db.QueryRow(query, ledgerID, id).
 			Scan(
 				&transaction.ID,
 				&transaction.UserId,
@@ -199,6 +313,7 @@ func GetTransactionById(db *sql.DB) gin.HandlerFunc {
 		}
 
 		// success response
+		c.Header("ETag", entityTag(transaction.UpdatedAt))
 		c.JSON(http.StatusOK, gin.H{
 			"status":  http.StatusOK,
 			"message": "Success!",
@@ -215,6 +330,91 @@ type transactionReq struct {
 	Notes    string `json:"notes"`
 }
 
+// insertTransaction creates a transaction in the ledger and records it in the audit trail
+func insertTransaction(tx *sql.Tx, c *gin.Context, ledgerID, userID int, req transactionReq) (models.TransactionSchema, error) {
+	var transaction models.TransactionSchema
+	query := `
+		INSERT INTO swordfish.transactions (ledger_id, user_id, type, amount, category, date, notes, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
+	`
+	err := scanTransaction(tx.QueryRow(query, ledgerID, userID, req.Type, req.Amount, req.Category, req.Date, req.Notes, time.Now(), time.Now()), &transaction)
+	if err != nil {
+		return transaction, err
+	}
+	return transaction, recordTransactionEvent(tx, c, ledgerID, audit.ActionCreate, nil, &transaction)
+}
+
+// lockTransaction reads an active transaction of the ledger and locks it until tx ends,
+// it returns sql.ErrNoRows when there is no such transaction
+func lockTransaction(tx *sql.Tx, ledgerID, id int) (models.TransactionSchema, error) {
+	var transaction models.TransactionSchema
+	err := scanTransaction(tx.QueryRow(`
+		SELECT id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
+		FROM swordfish.transactions
+		WHERE id = $1 AND ledger_id = $2 AND is_active = true
+		FOR UPDATE
+	`, id, ledgerID), &transaction)
+	return transaction, err
+}
+
+// newTransactionReq returns the request that would write the transaction as it is
+func newTransactionReq(transaction models.TransactionSchema) (transactionReq, error) {
+	amount, err := strconv.Atoi(transaction.Amount)
+	if err != nil {
+		return transactionReq{}, err
+	}
+	return transactionReq{
+		Type:     transaction.Type,
+		Amount:   amount,
+		Category: transaction.Category,
+		Date:     transaction.Date.Format("2006-01-02"),
+		Notes:    transaction.Notes,
+	}, nil
+}
+
+// updateTransaction overwrites an active transaction of the ledger and records the change in the audit trail,
+// it returns sql.ErrNoRows when there is no such transaction
+func updateTransaction(tx *sql.Tx, c *gin.Context, ledgerID, id int, req transactionReq) (models.TransactionSchema, error) {
+	// lock the row to record the values it had before the update
+	var transaction models.TransactionSchema
+	previous, err := lockTransaction(tx, ledgerID, id)
+	if err != nil {
+		return transaction, err
+	}
+
+	query := `
+		UPDATE swordfish.transactions
+		SET type = $1, amount = $2, category = $3, date = $4, notes = $5, updated_at = $6
+		WHERE id = $7
+		RETURNING id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
+	`
+	err = scanTransaction(tx.QueryRow(query, req.Type, req.Amount, req.Category, req.Date, req.Notes, time.Now(), id), &transaction)
+	if err != nil {
+		return transaction, err
+	}
+	return transaction, recordTransactionEvent(tx, c, ledgerID, audit.ActionUpdate, &previous, &transaction)
+}
+
+// trashTransaction moves an active transaction of the ledger to the trash and records it in the audit trail,
+// it returns sql.ErrNoRows when there is no such transaction
+func trashTransaction(tx *sql.Tx, c *gin.Context, ledgerID, id int) (models.TransactionSchema, error) {
+	var transaction models.TransactionSchema
+	query := `
+		UPDATE swordfish.transactions
+		SET is_active = false, deleted_at = $1, updated_at = $1
+		WHERE id = $2 AND ledger_id = $3 AND is_active = true
+		RETURNING id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
+	`
+	if err := scanTransaction(tx.QueryRow(query, time.Now(), id, ledgerID), &transaction); err != nil {
+		return transaction, err
+	}
+
+	previous := transaction
+	previous.IsActive = true
+	return transaction, recordTransactionEvent(tx, c, ledgerID, audit.ActionDelete, &previous, &transaction)
+}
+
 func PostCreateTransaction(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var createTxReq transactionReq
@@ -238,25 +438,17 @@ func PostCreateTransaction(db *sql.DB) gin.HandlerFunc {
 			})
 			return
 		}
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
 
-		query := `
-      INSERT INTO swordfish.transactions ( user_id, type, amount, category, date, notes, created_at, updated_at)
-      VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-      RETURNING id, user_id, type, amount, category, date, notes, created_at, updated_at
-    `
-		var newTransaction models.TransactionSchema
-		err := db.QueryRow(query, userID, createTxReq.Type, createTxReq.Amount, createTxReq.Category, createTxReq.Date, createTxReq.Notes, time.Now(), time.Now()).
-			Scan(
-				&newTransaction.ID,
-				&newTransaction.UserId,
-				&newTransaction.Type,
-				&newTransaction.Amount,
-				&newTransaction.Category,
-				&newTransaction.Date,
-				&newTransaction.Notes,
-				&newTransaction.CreatedAt,
-				&newTransaction.UpdatedAt,
-			)
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		newTransaction, err := insertTransaction(tx, c, ledgerID, int(userID), createTxReq)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  500,
@@ -266,6 +458,11 @@ func PostCreateTransaction(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"status":  http.StatusOK,
 			"message": "Success create transaction!",
@@ -300,14 +497,8 @@ func PutUpdateTransaction(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		userID, ok := c.MustGet("user_id").(float64)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized user",
-			})
-			return
-		}
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
 
 		// Validate request body
 		if err := c.ShouldBindJSON(&updateTxReq); err != nil {
@@ -319,26 +510,14 @@ func PutUpdateTransaction(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		var updatedTransaction models.TransactionSchema
-		query := `
-			UPDATE swordfish.transactions
-			SET type = $1, amount = $2, category = $3, date = $4, notes = $5, updated_at = $6
-			WHERE id = $7 AND user_id = $8 AND is_active = true
-			RETURNING id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
-		`
-		err = db.QueryRow(query, updateTxReq.Type, updateTxReq.Amount, updateTxReq.Category, updateTxReq.Date, updateTxReq.Notes, time.Now(), id, userID).
-			Scan(
-				&updatedTransaction.ID,
-				&updatedTransaction.UserId,
-				&updatedTransaction.Type,
-				&updatedTransaction.Amount,
-				&updatedTransaction.Category,
-				&updatedTransaction.Date,
-				&updatedTransaction.Notes,
-				&updatedTransaction.IsActive,
-				&updatedTransaction.CreatedAt,
-				&updatedTransaction.UpdatedAt,
-			)
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		currentTransaction, err := lockTransaction(tx, ledgerID, id)
 		if err == sql.ErrNoRows {
 			c.JSON(http.StatusNotFound, gin.H{
 				"status":  http.StatusNotFound,
@@ -353,8 +532,98 @@ func PutUpdateTransaction(db *sql.DB) gin.HandlerFunc {
 			})
 			return
 		}
+		if !checkIfMatch(c, currentTransaction.UpdatedAt) {
+			return
+		}
+
+		updatedTransaction, err := updateTransaction(tx, c, ledgerID, id, updateTxReq)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":  http.StatusInternalServerError,
+				"message": "Database error",
+				"error":   err.Error(),
+			})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
 
 		// return status success
+		c.Header("ETag", entityTag(updatedTransaction.UpdatedAt))
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success update transaction!",
+			"data":    updatedTransaction,
+		})
+	}
+}
+
+// PatchTransaction applies a JSON Merge Patch to a transaction, only the members present in the body change.
+// With If-Match the patch is only applied when the transaction still has that ETag, otherwise it responds 412.
+func PatchTransaction(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		patch, err := c.GetRawData()
+		if err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update transaction!", err.Error())
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		currentTransaction, err := lockTransaction(tx, ledgerID, id)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Transaction not found", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if !checkIfMatch(c, currentTransaction.UpdatedAt) {
+			return
+		}
+
+		// Validate the patched transaction
+		patchTxReq, err := newTransactionReq(currentTransaction)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to parse transaction data!", err.Error())
+			return
+		}
+		if err := mergePatch(&patchTxReq, patch); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update transaction!", err.Error())
+			return
+		}
+		if err := binding.Validator.ValidateStruct(&patchTxReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update transaction!", err.Error())
+			return
+		}
+
+		updatedTransaction, err := updateTransaction(tx, c, ledgerID, id, patchTxReq)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.Header("ETag", entityTag(updatedTransaction.UpdatedAt))
 		c.JSON(http.StatusOK, gin.H{
 			"status":  http.StatusOK,
 			"message": "Success update transaction!",
@@ -388,46 +657,34 @@ func DeleteTransaction(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		userID, ok := c.MustGet("user_id").(float64)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized user",
-			})
-			return
-		}
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
 
-		query := `
-      UPDATE swordfish.transactions
-      SET is_active = false, updated_at = $1
-      WHERE id = $2 AND user_id = $3 AND is_active = true
-    `
-		result, err := db.Exec(query, time.Now(), id, userID)
+		tx, err := db.Begin()
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"status":  http.StatusInternalServerError,
-				"message": "Database error",
-				"error":   err.Error(),
-			})
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
 			return
 		}
+		defer tx.Rollback()
 
-		// Check if any rows were affected
-		rowsAffected, err := result.RowsAffected()
-		if err != nil {
+		_, err = trashTransaction(tx, c, ledgerID, id)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{
+				"status":  http.StatusNotFound,
+				"message": "Transaction not found or already inactive",
+			})
+			return
+		} else if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  http.StatusInternalServerError,
-				"message": "Error checking update result",
+				"message": "Database error",
 				"error":   err.Error(),
 			})
 			return
 		}
 
-		if rowsAffected == 0 {
-			c.JSON(http.StatusNotFound, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Transaction not found or already inactive",
-			})
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
 			return
 		}
 
@@ -439,8 +696,8 @@ func DeleteTransaction(db *sql.DB) gin.HandlerFunc {
 }
 
 type monthlySummaryQueryReq struct {
-	DateStart string `form:"date_start" binding:"required"`
-	DateEnd   string `form:"date_end" binding:"required"`
+	transactionFilterReq
+	exportReq
 }
 
 type monthlySummaryData struct {
@@ -469,23 +726,29 @@ func GetMonthlySummary(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
-		userID, ok := c.MustGet("user_id").(float64)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"status":  http.StatusUnauthorized,
-				"message": "Unauthorized user",
+		if queryReq.DateStart == "" || queryReq.DateEnd == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "Invalid query parameters!",
+				"errors":  "date_start and date_end are required",
 			})
 			return
 		}
 
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		qb := newTransactionQuery(ledgerID)
+		queryReq.apply(qb)
+
 		summaryQuery := `
       SELECT category, SUM(amount) AS total_amount, COUNT(id) as count
       FROM swordfish.transactions as tx
-      WHERE tx.user_id = $1 AND tx.is_active = true AND tx.date BETWEEN $2 AND $3
+      WHERE ` + qb.sql() + `
       GROUP BY category;
     `
 		// Execute query
-		summaryRows, err := db.Query(summaryQuery, userID, queryReq.DateStart, queryReq.DateEnd)
+		summaryRows, err := db.Query(summaryQuery, qb.params()...)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  http.StatusInternalServerError,
@@ -504,7 +767,6 @@ func GetMonthlySummary(db *sql.DB) gin.HandlerFunc {
 				&summary.TotalAmount,
 				&summary.Count,
 			)
-			log.Println(summary)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{
 					"status":  http.StatusInternalServerError,
@@ -528,11 +790,11 @@ func GetMonthlySummary(db *sql.DB) gin.HandlerFunc {
 		cashflowQuery := `
       SELECT type, SUM(amount) as cashflow
       FROM swordfish.transactions as tx 
-      WHERE tx.user_id = $1 AND tx.is_active = true AND tx.date BETWEEN $2 AND $3
+      WHERE ` + qb.sql() + `
       GROUP BY type
     `
 		// Execute query
-		cashflowRows, err := db.Query(cashflowQuery, userID, queryReq.DateStart, queryReq.DateEnd)
+		cashflowRows, err := db.Query(cashflowQuery, qb.params()...)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{
 				"status":  http.StatusInternalServerError,
@@ -550,7 +812,6 @@ func GetMonthlySummary(db *sql.DB) gin.HandlerFunc {
 				&cashflow.Type,
 				&cashflow.Cashflow,
 			)
-			log.Println(cashflow)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{
 					"status":  http.StatusInternalServerError,
@@ -576,6 +837,26 @@ func GetMonthlySummary(db *sql.DB) gin.HandlerFunc {
 			cashflowMap[cashflow.Type] += cashflow.Cashflow
 		}
 
+		if queryReq.isExport() {
+			w := newExportWriter(c, queryReq.Format, fmt.Sprintf("monthly-summary-%s-%s", queryReq.DateStart, queryReq.DateEnd))
+			err := w.WriteSheet("summary", "category", "total_amount", "count")
+			for _, summary := range summaryData {
+				if err == nil {
+					err = w.WriteRow(summary.Category, summary.TotalAmount, summary.Count)
+				}
+			}
+			if err == nil {
+				err = w.WriteSheet("cashflow", "type", "cashflow")
+			}
+			for _, cashflowType := range []string{"inflow", "outflow"} {
+				if err == nil {
+					err = w.WriteRow(cashflowType, cashflowMap[cashflowType])
+				}
+			}
+			closeExport(c, w, err)
+			return
+		}
+
 		c.JSON(http.StatusOK, gin.H{
 			"status":  http.StatusOK,
 			"message": "Successs!",
@@ -0,0 +1,275 @@
+package routes
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+)
+
+// maxBulkItems limits the operations of one request and the transactions one filter may patch
+const maxBulkItems = 500
+
+var errBulkTooManyMatches = fmt.Errorf("filter matches more than %d transactions, narrow it down", maxBulkItems)
+
+type bulkOperationReq struct {
+	Op   string          `json:"op"`
+	ID   int             `json:"id"`
+	Data *transactionReq `json:"data"`
+}
+
+// bulkPatchReq holds the fields set on every transaction matching the filter, nil fields are left unchanged
+type bulkPatchReq struct {
+	Type     *string `json:"type" binding:"omitempty,oneof=inflow outflow"`
+	Amount   *int    `json:"amount" binding:"omitempty,min=1"`
+	Category *string `json:"category" binding:"omitempty,min=1"`
+	Date     *string `json:"date" binding:"omitempty,datetime=2006-01-02"`
+	Notes    *string `json:"notes"`
+}
+
+func (p bulkPatchReq) isEmpty() bool {
+	return p.Type == nil && p.Amount == nil && p.Category == nil && p.Date == nil && p.Notes == nil
+}
+
+// apply returns the transaction with the patched fields replaced
+func (p bulkPatchReq) apply(transaction models.TransactionSchema) (transactionReq, error) {
+	req, err := newTransactionReq(transaction)
+	if err != nil {
+		return req, err
+	}
+	if p.Type != nil {
+		req.Type = *p.Type
+	}
+	if p.Amount != nil {
+		req.Amount = *p.Amount
+	}
+	if p.Category != nil {
+		req.Category = *p.Category
+	}
+	if p.Date != nil {
+		req.Date = *p.Date
+	}
+	if p.Notes != nil {
+		req.Notes = *p.Notes
+	}
+	return req, nil
+}
+
+// bulkTransactionReq is either a list of operations, or a filter with a patch
+type bulkTransactionReq struct {
+	Operations []bulkOperationReq    `json:"operations"`
+	Filter     *transactionFilterReq `json:"filter"`
+	Patch      *bulkPatchReq         `json:"patch"`
+}
+
+type bulkItemResult struct {
+	Index  int                       `json:"index"`
+	Op     string                    `json:"op"`
+	ID     int                       `json:"id,omitempty"`
+	Status string                    `json:"status"`
+	Data   *models.TransactionSchema `json:"data,omitempty"`
+	Error  string                    `json:"error,omitempty"`
+}
+
+// validate checks one operation before anything is written
+func (op bulkOperationReq) validate() error {
+	switch op.Op {
+	case "create":
+		if op.ID != 0 {
+			return errors.New("id must not be set on create")
+		}
+		if op.Data == nil {
+			return errors.New("data is required")
+		}
+		return binding.Validator.ValidateStruct(op.Data)
+	case "update":
+		if op.ID <= 0 {
+			return errors.New("id is required")
+		}
+		if op.Data == nil {
+			return errors.New("data is required")
+		}
+		return binding.Validator.ValidateStruct(op.Data)
+	case "delete":
+		if op.ID <= 0 {
+			return errors.New("id is required")
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid op %q, expected create, update or delete", op.Op)
+	}
+}
+
+// PostBulkTransactions applies a batch of operations in a single DB transaction: either every
+// operation succeeds or nothing is changed. Instead of operations the body may contain a filter
+// and a patch, which updates every matching transaction.
+func PostBulkTransactions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var bulkReq bulkTransactionReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&bulkReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to apply bulk operations!", err.Error())
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		withFilter := bulkReq.Filter != nil || bulkReq.Patch != nil
+		switch {
+		case withFilter && len(bulkReq.Operations) > 0:
+			utils.RespondError(c, http.StatusBadRequest, "Failed to apply bulk operations!", "send either operations or a filter with a patch, not both")
+			return
+		case withFilter && (bulkReq.Filter == nil || bulkReq.Filter.isEmpty()):
+			utils.RespondError(c, http.StatusBadRequest, "Failed to apply bulk operations!", "filter must set at least one condition")
+			return
+		case withFilter && (bulkReq.Patch == nil || bulkReq.Patch.isEmpty()):
+			utils.RespondError(c, http.StatusBadRequest, "Failed to apply bulk operations!", "patch must set at least one field")
+			return
+		case !withFilter && len(bulkReq.Operations) == 0:
+			utils.RespondError(c, http.StatusBadRequest, "Failed to apply bulk operations!", "operations are required")
+			return
+		case len(bulkReq.Operations) > maxBulkItems:
+			utils.RespondError(c, http.StatusBadRequest, "Failed to apply bulk operations!", fmt.Sprintf("more than %d operations", maxBulkItems))
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		var results []bulkItemResult
+		if withFilter {
+			results, err = patchTransactions(tx, c, ledgerID, *bulkReq.Filter, *bulkReq.Patch)
+			if err == errBulkTooManyMatches {
+				utils.RespondError(c, http.StatusBadRequest, "Failed to apply bulk operations!", err.Error())
+				return
+			} else if err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to apply bulk operations!", err.Error())
+				return
+			}
+		} else {
+			// every operation is validated first, nothing is written if any of them is invalid
+			results = make([]bulkItemResult, len(bulkReq.Operations))
+			invalid := false
+			for i, op := range bulkReq.Operations {
+				results[i] = bulkItemResult{Index: i, Op: op.Op, ID: op.ID, Status: "valid"}
+				if err := op.validate(); err != nil {
+					results[i].Status = "invalid"
+					results[i].Error = err.Error()
+					invalid = true
+				}
+			}
+			if invalid {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"status":  http.StatusBadRequest,
+					"message": "Failed to apply bulk operations, some operations are invalid!",
+					"data":    gin.H{"items": results},
+				})
+				return
+			}
+
+			for i, op := range bulkReq.Operations {
+				var transaction models.TransactionSchema
+				switch op.Op {
+				case "create":
+					transaction, err = insertTransaction(tx, c, ledgerID, int(userID), *op.Data)
+					results[i].Status = "created"
+				case "update":
+					transaction, err = updateTransaction(tx, c, ledgerID, op.ID, *op.Data)
+					results[i].Status = "updated"
+				case "delete":
+					transaction, err = trashTransaction(tx, c, ledgerID, op.ID)
+					results[i].Status = "deleted"
+				}
+				if err == sql.ErrNoRows {
+					utils.RespondError(c, http.StatusNotFound, "Failed to apply bulk operations, nothing was changed!",
+						fmt.Sprintf("operation %d: transaction %d not found", i, op.ID))
+					return
+				} else if err != nil {
+					utils.RespondError(c, http.StatusInternalServerError, "Failed to apply bulk operations, nothing was changed!",
+						fmt.Sprintf("operation %d: %v", i, err))
+					return
+				}
+				results[i].ID = transaction.ID
+				results[i].Data = &transaction
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		summary := gin.H{"total": len(results), "created": 0, "updated": 0, "deleted": 0}
+		for _, result := range results {
+			summary[result.Status] = summary[result.Status].(int) + 1
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success bulk transactions!",
+			"data": gin.H{
+				"summary": summary,
+				"items":   results,
+			},
+		})
+	}
+}
+
+// patchTransactions applies the patch to every active transaction of the ledger matching the filter
+func patchTransactions(tx *sql.Tx, c *gin.Context, ledgerID int, filter transactionFilterReq, patch bulkPatchReq) ([]bulkItemResult, error) {
+	qb := newTransactionQuery(ledgerID)
+	filter.apply(qb)
+
+	// one extra row tells whether the filter matches too many transactions
+	query := `
+		SELECT id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
+		FROM swordfish.transactions
+		WHERE ` + qb.sql() + fmt.Sprintf(" ORDER BY id LIMIT %d FOR UPDATE", maxBulkItems+1)
+	rows, err := tx.Query(query, qb.params()...)
+	if err != nil {
+		return nil, err
+	}
+	var matches []models.TransactionSchema
+	for rows.Next() {
+		var transaction models.TransactionSchema
+		if err := scanTransaction(rows, &transaction); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		matches = append(matches, transaction)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(matches) > maxBulkItems {
+		return nil, errBulkTooManyMatches
+	}
+
+	results := []bulkItemResult{}
+	for i, match := range matches {
+		req, err := patch.apply(match)
+		if err != nil {
+			return nil, err
+		}
+		transaction, err := updateTransaction(tx, c, ledgerID, match.ID, req)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, bulkItemResult{Index: i, Op: "update", ID: transaction.ID, Status: "updated", Data: &transaction})
+	}
+	return results, nil
+}
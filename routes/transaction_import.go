@@ -0,0 +1,365 @@
+package routes
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/halosatrio/xwing/audit"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+)
+
+const (
+	maxImportFileSize = 5 << 20
+	maxImportRows     = 5000
+)
+
+// importMappingReq describes how the CSV columns map to a transaction.
+// Columns are referenced by header name, or by 1-based index when has_header is false.
+type importMappingReq struct {
+	DateColumn         string `form:"date_column" binding:"required"`
+	DateFormat         string `form:"date_format"`
+	AmountColumn       string `form:"amount_column" binding:"required"`
+	AmountSign         string `form:"amount_sign" binding:"omitempty,oneof=negative_outflow positive_outflow type_column all_outflow all_inflow"`
+	ThousandsSeparator string `form:"thousands_separator"`
+	TypeColumn         string `form:"type_column"`
+	CategoryColumn     string `form:"category_column"`
+	DefaultCategory    string `form:"default_category"`
+	NotesColumn        string `form:"notes_column"`
+	Delimiter          string `form:"delimiter"`
+	HasHeader          *bool  `form:"has_header"`
+	DryRun             bool   `form:"dry_run"`
+}
+
+type importRowResult struct {
+	Row   int             `json:"row"`
+	Data  *transactionReq `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+// importColumns holds the resolved zero-based column indexes, -1 when not mapped
+type importColumns struct {
+	date, amount, txType, category, notes int
+}
+
+// resolveColumn finds a column by header name or 1-based index
+func resolveColumn(ref string, header []string) (int, error) {
+	if ref == "" {
+		return -1, nil
+	}
+	for i, name := range header {
+		if strings.EqualFold(strings.TrimSpace(name), strings.TrimSpace(ref)) {
+			return i, nil
+		}
+	}
+	if index, err := strconv.Atoi(ref); err == nil && index >= 1 {
+		return index - 1, nil
+	}
+	return -1, fmt.Errorf("column %q not found", ref)
+}
+
+// parseImportAmount parses amounts like "1,250,000", "-15000", "(15000)" or "12.50"
+func parseImportAmount(value, thousandsSeparator string) (int, error) {
+	value = strings.TrimSpace(value)
+	negative := false
+	if strings.HasPrefix(value, "(") && strings.HasSuffix(value, ")") {
+		negative = true
+		value = strings.Trim(value, "()")
+	}
+	if thousandsSeparator != "" {
+		value = strings.ReplaceAll(value, thousandsSeparator, "")
+	}
+	if thousandsSeparator == "." {
+		value = strings.Replace(value, ",", ".", 1)
+	}
+	value = strings.ReplaceAll(value, " ", "")
+
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", value)
+	}
+	if negative {
+		amount = -amount
+	}
+	return int(math.Round(amount)), nil
+}
+
+// parseImportRow converts a CSV record into a transaction request and validates it
+// with the same rules as POST /transaction/create
+func parseImportRow(record []string, cols importColumns, mapping importMappingReq) (*transactionReq, error) {
+	field := func(index int) string {
+		if index < 0 || index >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[index])
+	}
+
+	date, err := time.Parse(mapping.DateFormat, field(cols.date))
+	if err != nil {
+		return nil, fmt.Errorf("invalid date %q, expected format %s", field(cols.date), mapping.DateFormat)
+	}
+
+	amount, err := parseImportAmount(field(cols.amount), mapping.ThousandsSeparator)
+	if err != nil {
+		return nil, err
+	}
+
+	var txType string
+	switch mapping.AmountSign {
+	case "negative_outflow":
+		txType = "inflow"
+		if amount < 0 {
+			txType = "outflow"
+		}
+	case "positive_outflow":
+		txType = "outflow"
+		if amount < 0 {
+			txType = "inflow"
+		}
+	case "type_column":
+		txType = strings.ToLower(field(cols.txType))
+		if txType != "inflow" && txType != "outflow" {
+			return nil, fmt.Errorf("invalid type %q, expected inflow or outflow", field(cols.txType))
+		}
+	case "all_outflow":
+		txType = "outflow"
+	case "all_inflow":
+		txType = "inflow"
+	}
+	if amount < 0 {
+		amount = -amount
+	}
+
+	category := field(cols.category)
+	if category == "" {
+		category = mapping.DefaultCategory
+	}
+
+	req := &transactionReq{
+		Type:     txType,
+		Amount:   amount,
+		Category: category,
+		Date:     date.Format("2006-01-02"),
+		Notes:    field(cols.notes),
+	}
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// PostImportTransactions imports a CSV bank statement. Every row is validated first,
+// nothing is inserted if any row fails, and dry_run=true only returns the parsed rows.
+func PostImportTransactions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var mapping importMappingReq
+
+		// Validate form fields
+		if err := c.ShouldBind(&mapping); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to import transactions!", err.Error())
+			return
+		}
+		if mapping.DateFormat == "" {
+			mapping.DateFormat = "2006-01-02"
+		}
+		if mapping.AmountSign == "" {
+			mapping.AmountSign = "negative_outflow"
+		}
+		if mapping.ThousandsSeparator == "" {
+			mapping.ThousandsSeparator = ","
+		}
+		if mapping.AmountSign == "type_column" && mapping.TypeColumn == "" {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to import transactions!", "type_column is required when amount_sign is type_column")
+			return
+		}
+		if mapping.CategoryColumn == "" && mapping.DefaultCategory == "" {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to import transactions!", "category_column or default_category is required")
+			return
+		}
+		hasHeader := mapping.HasHeader == nil || *mapping.HasHeader
+
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to import transactions!", "file is required")
+			return
+		}
+		if fileHeader.Size > maxImportFileSize {
+			utils.RespondError(c, http.StatusRequestEntityTooLarge, "Failed to import transactions!", "file is larger than 5MB")
+			return
+		}
+		file, err := fileHeader.Open()
+		if err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to import transactions!", err.Error())
+			return
+		}
+		defer file.Close()
+
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		if mapping.Delimiter != "" {
+			delimiter := []rune(mapping.Delimiter)
+			if len(delimiter) != 1 {
+				utils.RespondError(c, http.StatusBadRequest, "Failed to import transactions!", "delimiter must be a single character")
+				return
+			}
+			reader.Comma = delimiter[0]
+		}
+
+		var header []string
+		if hasHeader {
+			header, err = reader.Read()
+			if err != nil {
+				utils.RespondError(c, http.StatusBadRequest, "Failed to import transactions!", "failed to read CSV header")
+				return
+			}
+		}
+
+		var cols importColumns
+		for _, col := range []struct {
+			target *int
+			ref    string
+		}{
+			{&cols.date, mapping.DateColumn},
+			{&cols.amount, mapping.AmountColumn},
+			{&cols.txType, mapping.TypeColumn},
+			{&cols.category, mapping.CategoryColumn},
+			{&cols.notes, mapping.NotesColumn},
+		} {
+			index, err := resolveColumn(col.ref, header)
+			if err != nil {
+				utils.RespondError(c, http.StatusBadRequest, "Failed to import transactions!", err.Error())
+				return
+			}
+			*col.target = index
+		}
+
+		// Parse and validate every row
+		var results []importRowResult
+		var valid []*transactionReq
+		invalidCount := 0
+		rowNumber := 0
+		if hasHeader {
+			rowNumber = 1
+		}
+		for {
+			record, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			rowNumber++
+			if err != nil {
+				results = append(results, importRowResult{Row: rowNumber, Error: err.Error()})
+				invalidCount++
+				continue
+			}
+			if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+				continue
+			}
+			if len(valid)+invalidCount >= maxImportRows {
+				utils.RespondError(c, http.StatusBadRequest, "Failed to import transactions!", fmt.Sprintf("file has more than %d rows", maxImportRows))
+				return
+			}
+
+			req, err := parseImportRow(record, cols, mapping)
+			if err != nil {
+				results = append(results, importRowResult{Row: rowNumber, Error: err.Error()})
+				invalidCount++
+				continue
+			}
+			results = append(results, importRowResult{Row: rowNumber, Data: req})
+			valid = append(valid, req)
+		}
+
+		summary := gin.H{
+			"total":   len(valid) + invalidCount,
+			"valid":   len(valid),
+			"invalid": invalidCount,
+			"dry_run": mapping.DryRun,
+		}
+
+		if invalidCount > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"status":  http.StatusBadRequest,
+				"message": "Failed to import transactions, some rows are invalid!",
+				"data": gin.H{
+					"summary": summary,
+					"rows":    results,
+				},
+			})
+			return
+		}
+
+		if mapping.DryRun {
+			c.JSON(http.StatusOK, gin.H{
+				"status":  http.StatusOK,
+				"message": "Success! (dry run, nothing imported)",
+				"data": gin.H{
+					"summary": summary,
+					"rows":    results,
+				},
+			})
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		// Insert all rows in a single DB transaction
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		stmt, err := tx.Prepare(`
+			INSERT INTO swordfish.transactions (ledger_id, user_id, type, amount, category, date, notes, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			RETURNING id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
+		`)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer stmt.Close()
+
+		now := time.Now()
+		for i, req := range valid {
+			var transaction models.TransactionSchema
+			if err := scanTransaction(stmt.QueryRow(ledgerID, userID, req.Type, req.Amount, req.Category, req.Date, req.Notes, now, now), &transaction); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, fmt.Sprintf("Failed to insert row %d into database!", results[i].Row), err.Error())
+				return
+			}
+			if err := recordTransactionEvent(tx, c, ledgerID, audit.ActionCreate, nil, &transaction); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to record audit event!", err.Error())
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to commit import!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success import transactions!",
+			"data": gin.H{
+				"summary": summary,
+			},
+		})
+	}
+}
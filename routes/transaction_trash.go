@@ -0,0 +1,186 @@
+package routes
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/audit"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+)
+
+// trashedTransaction is a deleted transaction with the time it was moved to the trash
+type trashedTransaction struct {
+	models.TransactionSchema
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// GetTrashTransactions lists the deleted transactions of the ledger, most recently deleted first
+func GetTrashTransactions(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		transactions := []trashedTransaction{}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		rows, err := db.Query(`
+			SELECT id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at, deleted_at
+			FROM swordfish.transactions
+			WHERE ledger_id = $1 AND is_active = false
+			ORDER BY deleted_at DESC, id DESC
+		`, ledgerID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to fetch transactions!", err.Error())
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var transaction trashedTransaction
+			err := rows.Scan(
+				&transaction.ID,
+				&transaction.UserId,
+				&transaction.Type,
+				&transaction.Amount,
+				&transaction.Category,
+				&transaction.Date,
+				&transaction.Notes,
+				&transaction.IsActive,
+				&transaction.CreatedAt,
+				&transaction.UpdatedAt,
+				&transaction.DeletedAt,
+			)
+			if err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to parse transaction data!", err.Error())
+				return
+			}
+			transactions = append(transactions, transaction)
+		}
+		if err := rows.Err(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error iterating over transactions!", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    transactions,
+		})
+	}
+}
+
+// PostRestoreTransaction moves a deleted transaction out of the trash
+func PostRestoreTransaction(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		var transaction models.TransactionSchema
+		query := `
+			UPDATE swordfish.transactions
+			SET is_active = true, deleted_at = NULL, updated_at = $1
+			WHERE id = $2 AND ledger_id = $3 AND is_active = false
+			RETURNING id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
+		`
+		err = scanTransaction(tx.QueryRow(query, time.Now(), id, ledgerID), &transaction)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Transaction not found in trash", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		previousTransaction := transaction
+		previousTransaction.IsActive = false
+		if err := recordTransactionEvent(tx, c, ledgerID, audit.ActionRestore, &previousTransaction, &transaction); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to record audit event!", err.Error())
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Transaction restored successfully",
+			"data":    transaction,
+		})
+	}
+}
+
+// DeletePurgeTransaction permanently deletes one transaction from the trash
+func DeletePurgeTransaction(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		result, err := db.Exec(`
+			DELETE FROM swordfish.transactions
+			WHERE id = $1 AND ledger_id = $2 AND is_active = false
+		`, id, ledgerID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error checking delete result", err.Error())
+			return
+		}
+		if rowsAffected == 0 {
+			utils.RespondError(c, http.StatusNotFound, "Transaction not found in trash", "")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Transaction purged successfully",
+		})
+	}
+}
+
+// DeleteEmptyTrash permanently deletes every transaction in the trash of the ledger
+func DeleteEmptyTrash(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// get ledger of the request
+		ledgerID, _ := c.MustGet("ledger_id").(int)
+
+		result, err := db.Exec(`DELETE FROM swordfish.transactions WHERE ledger_id = $1 AND is_active = false`, ledgerID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		purged, err := result.RowsAffected()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error checking delete result", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Trash emptied successfully",
+			"data":    gin.H{"purged": purged},
+		})
+	}
+}
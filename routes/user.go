@@ -0,0 +1,415 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/mailer"
+	"github.com/halosatrio/xwing/utils"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const emailChangeDuration = 24 * time.Hour
+
+// recentLoginDuration is how old a session can be to delete the account without the password,
+// accounts registered through an identity provider have no password the user knows
+const recentLoginDuration = 10 * time.Minute
+
+// userProfile is the user as returned by the API, without the password hash
+type userProfile struct {
+	ID              int        `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	PendingEmail    *string    `json:"pending_email"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// getUserProfile loads the profile of the user, with the email of a pending email change
+func getUserProfile(db *sql.DB, userID float64) (userProfile, error) {
+	var profile userProfile
+	err := db.QueryRow(`
+		SELECT u.id, u.username, u.email, u.email_verified_at, (
+			SELECT ec.email FROM swordfish.email_changes AS ec
+			WHERE ec.user_id = u.id AND ec.used_at IS NULL AND ec.expires_at > NOW()
+			ORDER BY ec.created_at DESC
+			LIMIT 1
+		), u.created_at, COALESCE(u.updated_at, u.created_at)
+		FROM swordfish.users AS u
+		WHERE u.id = $1 AND u.deleted_at IS NULL
+	`, userID).Scan(&profile.ID, &profile.Username, &profile.Email, &profile.EmailVerifiedAt, &profile.PendingEmail, &profile.CreatedAt, &profile.UpdatedAt)
+	return profile, err
+}
+
+// GetUser returns the profile of the logged in user
+func GetUser(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+		scopes, _ := c.Get("scopes")
+
+		profile, err := getUserProfile(db, userID)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "User not found", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		// Respond with success
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data": gin.H{
+				"user_id":           profile.ID,
+				"username":          profile.Username,
+				"email":             profile.Email,
+				"email_verified_at": profile.EmailVerifiedAt,
+				"pending_email":     profile.PendingEmail,
+				"created_at":        profile.CreatedAt,
+				"updated_at":        profile.UpdatedAt,
+				"scopes":            scopes,
+			},
+		})
+	}
+}
+
+type updateUserReq struct {
+	Username string `json:"username" binding:"required,max=64"`
+	Email    string `json:"email" binding:"required,email"`
+}
+
+// PutUpdateUser updates the profile. The username changes right away, a new email
+// is only applied once the link sent to it has been opened.
+func PutUpdateUser(db *sql.DB, mail mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var updateReq updateUserReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&updateReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to update user!", err.Error())
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		profile, err := getUserProfile(db, userID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		emailChanged := !strings.EqualFold(updateReq.Email, profile.Email)
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		// check the new email before writing anything, so a conflict leaves the profile untouched
+		var token string
+		if emailChanged {
+			var taken bool
+			if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM swordfish.users WHERE LOWER(email) = LOWER($1))`, updateReq.Email).Scan(&taken); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+				return
+			}
+			if taken {
+				utils.RespondError(c, http.StatusConflict, "Email is already registered", "")
+				return
+			}
+
+			token, err = utils.RandomToken(32)
+			if err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to generate verification token", err.Error())
+				return
+			}
+		}
+
+		if _, err := tx.Exec(`UPDATE swordfish.users SET username = $1, updated_at = $2 WHERE id = $3`, updateReq.Username, time.Now(), userID); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to update user!", err.Error())
+			return
+		}
+
+		if emailChanged {
+			// only the latest requested email can be confirmed, and expired claims on the new email are released
+			if _, err := tx.Exec(`
+				UPDATE swordfish.email_changes SET used_at = $1
+				WHERE used_at IS NULL AND (user_id = $2 OR (LOWER(email) = LOWER($3) AND expires_at <= $1))
+			`, time.Now(), userID, updateReq.Email); err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+				return
+			}
+			// the pending index allows one claim per email, a concurrent request for the same email lands here
+			_, err = tx.Exec(`
+				INSERT INTO swordfish.email_changes (user_id, email, token_hash, expires_at, created_at)
+				VALUES ($1, $2, $3, $4, $5)
+			`, userID, updateReq.Email, utils.HashToken(token), time.Now().Add(emailChangeDuration), time.Now())
+			if utils.IsUniqueViolation(err) {
+				utils.RespondError(c, http.StatusConflict, "Email is already registered", "")
+				return
+			} else if err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+				return
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		message := "Success update user!"
+		if emailChanged {
+			link := appLink("/confirm-email?token=" + url.QueryEscape(token))
+			body := fmt.Sprintf("You asked to change the email of your Xwing account to this address.\n\nOpen this link within %d hours to confirm it:\n%s\n\nIf it was not you, you can ignore this email.",
+				int(emailChangeDuration.Hours()), link)
+			if err := mail.Send(updateReq.Email, "Confirm your new Xwing email", body); err != nil {
+				log.Printf("[auth][user] failed to send email change confirmation: %v", err)
+				utils.RespondError(c, http.StatusInternalServerError, "Failed to send confirmation email", "")
+				return
+			}
+			message = "Success update user! Open the link sent to the new email to confirm it"
+		}
+
+		profile, err = getUserProfile(db, userID)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": message,
+			"data":    profile,
+		})
+	}
+}
+
+type confirmEmailChangeReq struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// PostConfirmEmailChange applies a pending email change with the token sent to the new email
+func PostConfirmEmailChange(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var confirmReq confirmEmailChangeReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&confirmReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to confirm email!", err.Error())
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		// consume the token, a second attempt with the same token finds no row
+		var userID int
+		var email string
+		err = tx.QueryRow(`
+			UPDATE swordfish.email_changes
+			SET used_at = $1
+			WHERE token_hash = $2 AND used_at IS NULL AND expires_at > $1
+			RETURNING user_id, email
+		`, time.Now(), utils.HashToken(confirmReq.Token)).Scan(&userID, &email)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid or expired confirmation token", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		// the link was opened from the new email, so it counts as verified
+		_, err = tx.Exec(`
+			UPDATE swordfish.users
+			SET email = $1, email_verified_at = $2, updated_at = $2
+			WHERE id = $3 AND deleted_at IS NULL
+		`, email, time.Now(), userID)
+		if utils.IsUniqueViolation(err) {
+			utils.RespondError(c, http.StatusConflict, "Email is already registered", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to update email!", err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success confirm email!",
+		})
+	}
+}
+
+type deleteUserReq struct {
+	Password string `json:"password"`
+}
+
+// DeleteUser deletes the account after checking the password, or without it when the session
+// logged in within recentLoginDuration. Ledgers only the user belongs to
+// are deleted with their transactions and assets. In shared ledgers the data stays for the other
+// members and the user row is anonymized, so it no longer identifies the author.
+func DeleteUser(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var deleteReq deleteUserReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&deleteReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to delete user!", err.Error())
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		// get session jwt
+		sessionID, _ := c.MustGet("session_id").(string)
+
+		if deleteReq.Password != "" {
+			var hashedPassword string
+			err := db.QueryRow(`SELECT password FROM swordfish.users WHERE id = $1`, userID).Scan(&hashedPassword)
+			if err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+				return
+			}
+			if bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(deleteReq.Password)) != nil {
+				utils.RespondError(c, http.StatusUnauthorized, "Password is incorrect", "")
+				return
+			}
+		} else {
+			var recent bool
+			err := db.QueryRow(`
+				SELECT EXISTS (SELECT 1 FROM swordfish.sessions WHERE id = $1 AND user_id = $2 AND created_at > $3)
+			`, sessionID, userID, time.Now().Add(-recentLoginDuration)).Scan(&recent)
+			if err != nil {
+				utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+				return
+			}
+			if !recent {
+				utils.RespondError(c, http.StatusUnauthorized, "Password is required", fmt.Sprintf("enter your password or log in again, a login from the last %d minutes is enough", int(recentLoginDuration.Minutes())))
+				return
+			}
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		// shared ledgers must not be left without an owner
+		var orphaned int
+		err = tx.QueryRow(`
+			SELECT COUNT(*)
+			FROM swordfish.ledger_members AS m
+			WHERE m.user_id = $1 AND m.role = 'owner'
+				AND EXISTS (SELECT 1 FROM swordfish.ledger_members WHERE ledger_id = m.ledger_id AND user_id <> $1)
+				AND NOT EXISTS (SELECT 1 FROM swordfish.ledger_members WHERE ledger_id = m.ledger_id AND user_id <> $1 AND role = 'owner')
+		`, userID).Scan(&orphaned)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		if orphaned > 0 {
+			utils.RespondError(c, http.StatusConflict, "Failed to delete user!", "transfer the ownership of your shared ledgers first")
+			return
+		}
+
+		if err := deleteUserData(tx, int(userID)); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to delete user data!", err.Error())
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "User deleted successfully",
+		})
+	}
+}
+
+// deleteUserData removes the ledgers without other members and the account data of the user,
+// then anonymizes the user row
+func deleteUserData(tx *sql.Tx, userID int) error {
+	var ledgerIDs []int64
+	err := tx.QueryRow(`
+		SELECT COALESCE(array_agg(m.ledger_id), '{}')
+		FROM swordfish.ledger_members AS m
+		WHERE m.user_id = $1
+			AND NOT EXISTS (SELECT 1 FROM swordfish.ledger_members WHERE ledger_id = m.ledger_id AND user_id <> $1)
+	`, userID).Scan(pq.Array(&ledgerIDs))
+	if err != nil {
+		return err
+	}
+
+	ledgerStatements := []string{
+		`DELETE FROM swordfish.audit_events WHERE ledger_id = ANY($1)`,
+		`DELETE FROM swordfish.transactions WHERE ledger_id = ANY($1)`,
+		`DELETE FROM swordfish.recurring_rules WHERE ledger_id = ANY($1)`,
+		`DELETE FROM swordfish.assets WHERE ledger_id = ANY($1)`,
+		`DELETE FROM swordfish.budgets WHERE ledger_id = ANY($1)`,
+		`DELETE FROM swordfish.ledgers WHERE id = ANY($1)`,
+	}
+	for _, statement := range ledgerStatements {
+		if _, err := tx.Exec(statement, pq.Array(ledgerIDs)); err != nil {
+			return err
+		}
+	}
+
+	if err := removeUserExports(tx, userID); err != nil {
+		return err
+	}
+
+	userStatements := []string{
+		// rules left in shared ledgers would keep creating transactions for the deleted user
+		`UPDATE swordfish.recurring_rules SET is_active = false, next_date = NULL, updated_at = NOW() WHERE user_id = $1`,
+		`DELETE FROM swordfish.ledger_members WHERE user_id = $1`,
+		`DELETE FROM swordfish.category_groups WHERE user_id = $1`,
+		`DELETE FROM swordfish.api_keys WHERE user_id = $1`,
+		`DELETE FROM swordfish.mfa_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM swordfish.user_mfa WHERE user_id = $1`,
+		`DELETE FROM swordfish.password_resets WHERE user_id = $1`,
+		`DELETE FROM swordfish.email_changes WHERE user_id = $1`,
+		`DELETE FROM swordfish.user_identities WHERE user_id = $1`,
+		`DELETE FROM swordfish.idempotency_keys WHERE user_id = $1`,
+		`DELETE FROM swordfish.login_attempts WHERE LOWER(email) = (SELECT LOWER(email) FROM swordfish.users WHERE id = $1)`,
+		`DELETE FROM swordfish.refresh_tokens WHERE session_id IN (SELECT id FROM swordfish.sessions WHERE user_id = $1)`,
+		`DELETE FROM swordfish.sessions WHERE user_id = $1`,
+		`UPDATE swordfish.users
+			SET username = 'deleted user', email = 'deleted-' || id || '@deleted.invalid', password = '',
+				updated_at = NOW(), deleted_at = NOW()
+			WHERE id = $1`,
+	}
+	for _, statement := range userStatements {
+		if _, err := tx.Exec(statement, userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+package routes
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+)
+
+// userExportDuration is how long a finished archive can be downloaded
+const userExportDuration = 24 * time.Hour
+
+// userExportTimeout is how long an export may stay pending, older pending exports were interrupted
+// by a restart and are marked failed so the user can start a new one
+const userExportTimeout = 30 * time.Minute
+
+// exportDir is where the archives are stored until they expire, EXPORT_DIR overrides the default
+func exportDir() string {
+	if dir := os.Getenv("EXPORT_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "xwing-exports")
+}
+
+type userExport struct {
+	ID          int        `json:"id"`
+	Status      string     `json:"status"`
+	Error       *string    `json:"error"`
+	DownloadURL *string    `json:"download_url"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// PostCreateUserExport starts building an archive of the user's data in the background
+func PostCreateUserExport(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		if err := expireUserExports(db); err != nil {
+			log.Printf("[auth][export] failed to remove expired exports: %v", err)
+		}
+
+		_, err := db.Exec(`
+			UPDATE swordfish.data_exports
+			SET status = 'failed', error = 'export was interrupted', completed_at = $1
+			WHERE user_id = $2 AND status = 'pending' AND created_at < $3
+		`, time.Now(), userID, time.Now().Add(-userExportTimeout))
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		// the partial unique index allows one pending export per user, also for concurrent requests
+		var export userExport
+		err = db.QueryRow(`
+			INSERT INTO swordfish.data_exports (user_id, status, created_at)
+			VALUES ($1, 'pending', $2)
+			RETURNING id, status, created_at
+		`, userID, time.Now()).Scan(&export.ID, &export.Status, &export.CreatedAt)
+		if utils.IsUniqueViolation(err) {
+			utils.RespondError(c, http.StatusConflict, "An export is already being prepared", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to insert export into database!", err.Error())
+			return
+		}
+
+		go runUserExport(db, export.ID, int(userID))
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":  http.StatusAccepted,
+			"message": "Export started, check its status until the download link is ready",
+			"data":    export,
+		})
+	}
+}
+
+// GetUserExport returns the status of an export, with the download link once it is ready
+func GetUserExport(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		var export userExport
+		err := db.QueryRow(`
+			SELECT id, status, error, expires_at, completed_at, created_at
+			FROM swordfish.data_exports
+			WHERE id = $1 AND user_id = $2
+		`, id, userID).Scan(&export.ID, &export.Status, &export.Error, &export.ExpiresAt, &export.CompletedAt, &export.CreatedAt)
+		if err == sql.ErrNoRows {
+			utils.RespondError(c, http.StatusNotFound, "Export not found", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if export.Status == "ready" && export.ExpiresAt != nil {
+			if export.ExpiresAt.Before(time.Now()) {
+				export.Status = "expired"
+			} else {
+				token, err := utils.GenerateDownloadToken(export.ID, *export.ExpiresAt)
+				if err != nil {
+					utils.RespondError(c, http.StatusInternalServerError, "Failed to generate download link", err.Error())
+					return
+				}
+				link := fmt.Sprintf("/v1/auth/user/export/%d/download?token=%s", export.ID, url.QueryEscape(token))
+				export.DownloadURL = &link
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success!",
+			"data":    export,
+		})
+	}
+}
+
+// GetDownloadUserExport serves a finished archive. The link is signed,
+// so it works without the Authorization header, e.g. from a browser.
+func GetDownloadUserExport(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := bindID(c)
+		if !ok {
+			return
+		}
+
+		exportID, err := utils.ParseDownloadToken(c.Query("token"))
+		if err != nil || exportID != id {
+			utils.RespondError(c, http.StatusUnauthorized, "Invalid or expired download link", "")
+			return
+		}
+
+		var filePath *string
+		var createdAt time.Time
+		err = db.QueryRow(`
+			SELECT file_path, created_at
+			FROM swordfish.data_exports
+			WHERE id = $1 AND status = 'ready' AND expires_at > $2
+		`, id, time.Now()).Scan(&filePath, &createdAt)
+		if err == sql.ErrNoRows || (err == nil && filePath == nil) {
+			utils.RespondError(c, http.StatusNotFound, "Export not found or expired", "")
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		c.FileAttachment(*filePath, "xwing-export-"+createdAt.Format("20060102")+".zip")
+	}
+}
+
+// runUserExport builds the archive and records the outcome on the export row
+func runUserExport(db *sql.DB, exportID, userID int) {
+	path, err := buildUserExport(db, exportID, userID)
+	if err != nil {
+		log.Printf("[auth][export] export %d failed: %v", exportID, err)
+		if _, err := db.Exec(`
+			UPDATE swordfish.data_exports SET status = 'failed', error = $1, completed_at = $2 WHERE id = $3 AND status = 'pending'
+		`, err.Error(), time.Now(), exportID); err != nil {
+			log.Printf("[auth][export] failed to update export %d: %v", exportID, err)
+		}
+		return
+	}
+
+	// an export that ran past the timeout was already marked failed, its archive is dropped
+	result, err := db.Exec(`
+		UPDATE swordfish.data_exports
+		SET status = 'ready', file_path = $1, completed_at = $2, expires_at = $3
+		WHERE id = $4 AND status = 'pending'
+	`, path, time.Now(), time.Now().Add(userExportDuration), exportID)
+	if err != nil {
+		log.Printf("[auth][export] failed to update export %d: %v", exportID, err)
+		removeExportFile(path)
+	} else if updated, _ := result.RowsAffected(); updated == 0 {
+		removeExportFile(path)
+	}
+}
+
+// buildUserExport writes the profile, every transaction the user created, including the deleted
+// ones, and the assets to a zip archive, each as JSON and CSV, and returns its path
+func buildUserExport(db *sql.DB, exportID, userID int) (string, error) {
+	profile, err := getUserProfile(db, float64(userID))
+	if err != nil {
+		return "", err
+	}
+	transactions, err := exportUserTransactions(db, userID)
+	if err != nil {
+		return "", err
+	}
+	assets, err := exportUserAssets(db, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(exportDir(), 0o700); err != nil {
+		return "", err
+	}
+	path := filepath.Join(exportDir(), fmt.Sprintf("user-%d-export-%d.zip", userID, exportID))
+
+	// write to a temporary file first, so a failed export never leaves a partial archive
+	tmp, err := os.CreateTemp(exportDir(), "export-*.zip.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	archive := zip.NewWriter(tmp)
+	err = writeArchiveJSON(archive, "profile.json", profile)
+	if err == nil {
+		err = writeArchiveCSV(archive, "profile.csv", []string{"id", "username", "email", "email_verified_at", "created_at"},
+			[][]interface{}{{profile.ID, profile.Username, profile.Email, formatOptionalTime(profile.EmailVerifiedAt), profile.CreatedAt.Format(time.RFC3339)}})
+	}
+	if err == nil {
+		err = writeArchiveJSON(archive, "transactions.json", transactions)
+	}
+	if err == nil {
+		rows := make([][]interface{}, len(transactions))
+		for i, transaction := range transactions {
+			rows[i] = []interface{}{transaction.ID, transaction.Date, transaction.Type, transaction.Category, transaction.Amount,
+				transaction.Notes, transaction.IsActive, transaction.CreatedAt.Format(time.RFC3339), transaction.UpdatedAt.Format(time.RFC3339)}
+		}
+		err = writeArchiveCSV(archive, "transactions.csv",
+			[]string{"id", "date", "type", "category", "amount", "notes", "is_active", "created_at", "updated_at"}, rows)
+	}
+	if err == nil {
+		err = writeArchiveJSON(archive, "assets.json", assets)
+	}
+	if err == nil {
+		rows := make([][]interface{}, len(assets))
+		for i, asset := range assets {
+			rows[i] = []interface{}{asset.ID, asset.Date, asset.Account, asset.Amount, asset.Notes,
+				asset.CreatedAt.Format(time.RFC3339), asset.UpdatedAt.Format(time.RFC3339)}
+		}
+		err = writeArchiveCSV(archive, "assets.csv", []string{"id", "date", "account", "amount", "notes", "created_at", "updated_at"}, rows)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return path, os.Rename(tmp.Name(), path)
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func writeArchiveJSON(archive *zip.Writer, name string, value interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func writeArchiveCSV(archive *zip.Writer, name string, headers []string, rows [][]interface{}) error {
+	file, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	w := &csvExportWriter{writer: csv.NewWriter(file)}
+	if err := w.WriteSheet(name, headers...); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := w.WriteRow(row...); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// exportUserTransactions returns every transaction created by the user in any ledger, deleted ones included
+func exportUserTransactions(db *sql.DB, userID int) ([]models.TransactionSchema, error) {
+	transactions := []models.TransactionSchema{}
+	rows, err := db.Query(`
+		SELECT id, user_id, type, amount, category, date, COALESCE(notes, ''), is_active, created_at, updated_at
+		FROM swordfish.transactions
+		WHERE user_id = $1
+		ORDER BY date, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var transaction models.TransactionSchema
+		if err := scanTransaction(rows, &transaction); err != nil {
+			return nil, err
+		}
+		transactions = append(transactions, transaction)
+	}
+	return transactions, rows.Err()
+}
+
+// exportUserAssets returns every asset created by the user in any ledger
+func exportUserAssets(db *sql.DB, userID int) ([]models.AssetSchema, error) {
+	assets := []models.AssetSchema{}
+	rows, err := db.Query(`
+		SELECT id, user_id, account, amount, date, COALESCE(notes, ''), created_at, updated_at
+		FROM swordfish.assets
+		WHERE user_id = $1
+		ORDER BY date, id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var asset models.AssetSchema
+		if err := rows.Scan(&asset.ID, &asset.UserId, &asset.Account, &asset.Amount, &asset.Date, &asset.Notes, &asset.CreatedAt, &asset.UpdatedAt); err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	return assets, rows.Err()
+}
+
+// expireUserExports removes the archives whose download window has passed
+func expireUserExports(db *sql.DB) error {
+	rows, err := db.Query(`
+		WITH expired AS (
+			SELECT id, file_path FROM swordfish.data_exports
+			WHERE status = 'ready' AND expires_at <= $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE swordfish.data_exports AS e
+		SET status = 'expired', file_path = NULL
+		FROM expired
+		WHERE e.id = expired.id
+		RETURNING expired.file_path
+	`, time.Now())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path *string
+		if err := rows.Scan(&path); err != nil {
+			return err
+		}
+		if path != nil {
+			removeExportFile(*path)
+		}
+	}
+	return rows.Err()
+}
+
+// removeUserExports deletes the archives and export rows of the user
+func removeUserExports(tx *sql.Tx, userID int) error {
+	rows, err := tx.Query(`DELETE FROM swordfish.data_exports WHERE user_id = $1 RETURNING file_path`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path *string
+		if err := rows.Scan(&path); err != nil {
+			return err
+		}
+		if path != nil {
+			removeExportFile(*path)
+		}
+	}
+	return rows.Err()
+}
+
+func removeExportFile(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Printf("[auth][export] failed to remove %s: %v", path, err)
+	}
+}
@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/halosatrio/xwing/mailer"
+	"github.com/halosatrio/xwing/utils"
+)
+
+// emailVerificationRequired makes LoginUser refuse unverified accounts, set REQUIRE_EMAIL_VERIFICATION=true to enable it
+func emailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true"
+}
+
+// sendVerificationEmail emails a signed verification link for the email of the user
+func sendVerificationEmail(mail mailer.Mailer, userID int, email string) error {
+	token, err := utils.GenerateVerificationToken(userID, email)
+	if err != nil {
+		return err
+	}
+
+	link := appLink("/verify-email?token=" + url.QueryEscape(token))
+	body := fmt.Sprintf("Welcome to Xwing!\n\nOpen this link within 24 hours to verify your email:\n%s\n\nIf you did not create an account, you can ignore this email.", link)
+	return mail.Send(email, "Verify your Xwing email", body)
+}
+
+type verifyEmailReq struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// PostVerifyEmail marks the email of the user as verified with the token of a verification link
+func PostVerifyEmail(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var verifyReq verifyEmailReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&verifyReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to verify email!", err.Error())
+			return
+		}
+
+		userID, email, err := utils.ParseVerificationToken(verifyReq.Token)
+		if err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid or expired verification token", "")
+			return
+		}
+
+		// the token only verifies the email it was sent to
+		result, err := db.Exec(`
+			UPDATE swordfish.users
+			SET email_verified_at = COALESCE(email_verified_at, $1)
+			WHERE id = $2 AND email = $3 AND deleted_at IS NULL
+		`, time.Now(), userID, email)
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Error checking update result", err.Error())
+			return
+		}
+		if rowsAffected == 0 {
+			utils.RespondError(c, http.StatusBadRequest, "Invalid or expired verification token", "")
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  http.StatusOK,
+			"message": "Success verify email!",
+		})
+	}
+}
+
+type resendVerificationReq struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// PostResendVerification sends a new verification link. Like PostForgotPassword it responds
+// the same way for unknown and already verified emails.
+func PostResendVerification(db *sql.DB, mail mailer.Mailer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var resendReq resendVerificationReq
+
+		// Validate request body
+		if err := c.ShouldBindJSON(&resendReq); err != nil {
+			utils.RespondError(c, http.StatusBadRequest, "Failed to resend verification!", err.Error())
+			return
+		}
+
+		respondSent := func() {
+			c.JSON(http.StatusOK, gin.H{
+				"status":  http.StatusOK,
+				"message": "If the email is registered and not verified yet, a verification link has been sent",
+			})
+		}
+
+		var userID int
+		var email string
+		err := db.QueryRow(`
+			SELECT id, email FROM swordfish.users
+			WHERE email = $1 AND email_verified_at IS NULL AND deleted_at IS NULL
+		`, resendReq.Email).Scan(&userID, &email)
+		if err == sql.ErrNoRows {
+			respondSent()
+			return
+		} else if err != nil {
+			utils.RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			return
+		}
+
+		if err := sendVerificationEmail(mail, userID, email); err != nil {
+			log.Printf("[auth][verify] failed to send verification email: %v", err)
+			utils.RespondError(c, http.StatusInternalServerError, "Failed to send verification email", "")
+			return
+		}
+
+		respondSent()
+	}
+}
@@ -0,0 +1,164 @@
+package scheduler
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/halosatrio/xwing/audit"
+	"github.com/halosatrio/xwing/models"
+	"github.com/halosatrio/xwing/utils"
+)
+
+// StartRecurring runs the recurring transaction job once on startup, catching up on
+// occurrences missed while the server was down, then again on every tick of interval
+func StartRecurring(db *sql.DB, interval time.Duration) {
+	go func() {
+		for {
+			if err := RunRecurring(db, time.Now()); err != nil {
+				log.Printf("[scheduler][recurring] %v", err)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// RunRecurring materializes every occurrence due on or before now into swordfish.transactions
+func RunRecurring(db *sql.DB, now time.Time) error {
+	today := now.Format("2006-01-02")
+
+	rows, err := db.Query(`
+		SELECT id
+		FROM swordfish.recurring_rules
+		WHERE is_active = true AND next_date <= $1
+		ORDER BY next_date
+	`, today)
+	if err != nil {
+		return err
+	}
+
+	var ruleIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ruleIDs = append(ruleIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ruleIDs {
+		created, err := materializeRule(db, id, today)
+		if err != nil {
+			log.Printf("[scheduler][recurring] rule %d: %v", id, err)
+			continue
+		}
+		if created > 0 {
+			log.Printf("[scheduler][recurring] rule %d: created %d transaction(s)", id, created)
+		}
+	}
+	return nil
+}
+
+// materializeRule inserts all due occurrences of one rule in a single DB transaction.
+// The rule row is locked with SKIP LOCKED so concurrent instances never process it twice,
+// and the unique (recurring_rule_id, date) index makes a re-run after a crash harmless.
+func materializeRule(db *sql.DB, id int, today string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var (
+		ledgerID    int
+		userID      int
+		txType      string
+		amount      int
+		category    string
+		notes       string
+		frequency   string
+		interval    int
+		startDate   time.Time
+		endDate     *time.Time
+		count       *int
+		occurrences int
+	)
+	err = tx.QueryRow(`
+		SELECT ledger_id, user_id, type, amount, category, COALESCE(notes, ''), frequency, repeat_interval,
+			start_date, end_date, count, occurrences
+		FROM swordfish.recurring_rules
+		WHERE id = $1 AND is_active = true AND next_date <= $2
+		FOR UPDATE SKIP LOCKED
+	`, id, today).Scan(&ledgerID, &userID, &txType, &amount, &category, &notes, &frequency, &interval,
+		&startDate, &endDate, &count, &occurrences)
+	if err == sql.ErrNoRows {
+		// already processed or locked by another instance
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	until, err := time.Parse("2006-01-02", today)
+	if err != nil {
+		return 0, err
+	}
+
+	created := 0
+	next := utils.NextOccurrence(startDate, frequency, interval, occurrences, endDate, count)
+	for next != nil && !next.After(until) {
+		// no row is returned when the occurrence already exists
+		var transaction models.TransactionSchema
+		err := tx.QueryRow(`
+			INSERT INTO swordfish.transactions (ledger_id, user_id, type, amount, category, date, notes, recurring_rule_id, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+			ON CONFLICT (recurring_rule_id, date) WHERE recurring_rule_id IS NOT NULL DO NOTHING
+			RETURNING id, user_id, type, amount, category, date, notes, is_active, created_at, updated_at
+		`, ledgerID, userID, txType, amount, category, next.Format("2006-01-02"), notes, id, time.Now(), time.Now()).Scan(
+			&transaction.ID,
+			&transaction.UserId,
+			&transaction.Type,
+			&transaction.Amount,
+			&transaction.Category,
+			&transaction.Date,
+			&transaction.Notes,
+			&transaction.IsActive,
+			&transaction.CreatedAt,
+			&transaction.UpdatedAt,
+		)
+		if err == nil {
+			// generated transactions are recorded as created by the author of the rule
+			err = audit.Record(tx, audit.Event{
+				LedgerID: ledgerID,
+				ActorID:  userID,
+				Entity:   audit.EntityTransaction,
+				EntityID: transaction.ID,
+				Action:   audit.ActionCreate,
+				After:    &transaction,
+			})
+			if err != nil {
+				return 0, err
+			}
+			created++
+		} else if err != sql.ErrNoRows {
+			return 0, err
+		}
+		occurrences++
+		next = utils.NextOccurrence(startDate, frequency, interval, occurrences, endDate, count)
+	}
+
+	_, err = tx.Exec(`
+		UPDATE swordfish.recurring_rules
+		SET occurrences = $1, next_date = $2, updated_at = $3
+		WHERE id = $4
+	`, occurrences, next, time.Now(), id)
+	if err != nil {
+		return 0, err
+	}
+
+	return created, tx.Commit()
+}
@@ -0,0 +1,35 @@
+package scheduler
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// StartTrashPurge permanently deletes the transactions that have been in the trash
+// longer than retention, on startup and then on every tick of interval
+func StartTrashPurge(db *sql.DB, retention, interval time.Duration) {
+	go func() {
+		for {
+			purged, err := RunTrashPurge(db, time.Now(), retention)
+			if err != nil {
+				log.Printf("[scheduler][trash] %v", err)
+			} else if purged > 0 {
+				log.Printf("[scheduler][trash] purged %d transaction(s)", purged)
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// RunTrashPurge deletes the transactions deleted before now minus retention and returns how many were removed
+func RunTrashPurge(db *sql.DB, now time.Time, retention time.Duration) (int64, error) {
+	result, err := db.Exec(`
+		DELETE FROM swordfish.transactions
+		WHERE is_active = false AND deleted_at < $1
+	`, now.Add(-retention))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,115 @@
+package sso
+
+import (
+	"context"
+	"testing"
+
+	"github.com/halosatrio/xwing/sso/ssotest"
+	"golang.org/x/oauth2"
+)
+
+func newTestProvider(t *testing.T) (*Provider, *ssotest.Issuer) {
+	t.Helper()
+	issuer, err := ssotest.NewIssuer("xwing", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(issuer.Close)
+
+	t.Setenv("OIDC_ISSUER_URL", issuer.URL)
+	t.Setenv("OIDC_CLIENT_ID", "xwing")
+	t.Setenv("OIDC_CLIENT_SECRET", "secret")
+	return FromEnv("http://localhost:5173/oidc/callback"), issuer
+}
+
+func TestExchange(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := issuer.Authorize(authURL, ssotest.User{Subject: "u1", Email: "budi@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "state" {
+		t.Fatalf("state = %q, want %q", state, "state")
+	}
+
+	identity, err := provider.Exchange(ctx, code, verifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Identity{Issuer: issuer.URL, Subject: "u1", Email: "budi@example.com", EmailVerified: true}
+	if identity != want {
+		t.Fatalf("identity = %+v, want %+v", identity, want)
+	}
+
+	// codes can only be redeemed once
+	if _, err := provider.Exchange(ctx, code, verifier, "nonce"); err == nil {
+		t.Fatal("code was redeemed twice")
+	}
+}
+
+func TestExchangeUnverifiedEmail(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _, err := issuer.Authorize(authURL, ssotest.User{Subject: "u1", Email: "budi@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := provider.Exchange(ctx, code, verifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.EmailVerified {
+		t.Fatal("email reported as verified")
+	}
+}
+
+func TestExchangeNonceMismatch(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _, err := issuer.Authorize(authURL, ssotest.User{Subject: "u1", Email: "budi@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.Exchange(ctx, code, verifier, "other-nonce"); err == nil {
+		t.Fatal("ID token with another nonce was accepted")
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	provider, issuer := newTestProvider(t)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", oauth2.GenerateVerifier())
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _, err := issuer.Authorize(authURL, ssotest.User{Subject: "u1", Email: "budi@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := provider.Exchange(ctx, code, oauth2.GenerateVerifier(), "nonce"); err == nil {
+		t.Fatal("code was redeemed with another PKCE verifier")
+	}
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ApiKeyPrefix starts every personal API key, so leaked keys are easy to recognize
+const ApiKeyPrefix = "xw_"
+
+// apiKeyAuth authenticates an "Authorization: ApiKey <key>" request and sets the same
+// context keys as a JWT, with the scopes of the key. Read-only keys may only call safe methods.
+func apiKeyAuth(c *gin.Context, db *sql.DB, key string) {
+	var (
+		keyID    int
+		userID   int
+		email    string
+		readOnly bool
+		scopes   []string
+	)
+	err := db.QueryRow(`
+		SELECT k.id, k.user_id, u.email, k.read_only, k.scopes
+		FROM swordfish.api_keys AS k
+		JOIN swordfish.users AS u ON u.id = k.user_id
+		WHERE k.key_hash = $1 AND k.revoked_at IS NULL AND (k.expires_at IS NULL OR k.expires_at > $2)
+	`, HashToken(key), time.Now()).Scan(&keyID, &userID, &email, &readOnly, pq.Array(&scopes))
+	if err == sql.ErrNoRows {
+		ErrorResponseUnauthorizedJwt(c, "[middleware][apikey] Invalid, expired or revoked API key")
+		c.Abort()
+		return
+	} else if err != nil {
+		RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+		c.Abort()
+		return
+	}
+
+	if readOnly && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead && c.Request.Method != http.MethodOptions {
+		RespondError(c, http.StatusForbidden, "Failed", "[middleware][apikey] API key is read-only")
+		c.Abort()
+		return
+	}
+
+	if _, err := db.Exec(`UPDATE swordfish.api_keys SET last_used_at = $1 WHERE id = $2`, time.Now(), keyID); err != nil {
+		RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", float64(userID))
+	c.Set("email", email)
+	c.Set("api_key_id", keyID)
+	c.Set("scopes", scopes)
+	c.Next()
+}
+
+// RequireSession rejects requests authenticated with an API key, for account management
+// routes that must only be reachable from a logged in session
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("session_id"); !ok {
+			RespondError(c, http.StatusForbidden, "Failed", "[middleware][apikey] This route requires a login session")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -1,6 +1,11 @@
 package utils
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
 
 func RespondError(c *gin.Context, status int, message, err string) {
 	c.JSON(status, gin.H{
@@ -9,3 +14,9 @@ func RespondError(c *gin.Context, status int, message, err string) {
 		"error":   err,
 	})
 }
+
+// IsUniqueViolation reports whether err is a postgres unique constraint violation
+func IsUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader lets clients retry a create request without creating a duplicate
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyPendingTimeout is how long a request may run before its key is considered abandoned,
+// so a crash while handling the first attempt does not block the key for the whole window
+const idempotencyPendingTimeout = time.Minute
+
+// IdempotencyKeyDuration is how long a key and its response are kept, IDEMPOTENCY_KEY_TTL_HOURS overrides the default
+func IdempotencyKeyDuration() time.Duration {
+	duration := 24 * time.Hour // Default
+	if envDuration := os.Getenv("IDEMPOTENCY_KEY_TTL_HOURS"); envDuration != "" {
+		if hours, err := strconv.Atoi(envDuration); err == nil && hours > 0 {
+			duration = time.Duration(hours) * time.Hour
+		}
+	}
+	return duration
+}
+
+// responseRecorder keeps a copy of the response body to store it with the key
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// Idempotency replays the stored response when a request is retried with the same Idempotency-Key.
+// Keys are per user, a key reused with a different request responds 409, and requests without
+// the header are handled as usual. Server errors are not stored, so the client can retry them.
+func Idempotency(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			RespondError(c, http.StatusBadRequest, "Invalid Idempotency-Key", "key must be at most 255 characters")
+			c.Abort()
+			return
+		}
+
+		// get userid jwt
+		userID, _ := c.MustGet("user_id").(float64)
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			RespondError(c, http.StatusBadRequest, "Failed to read request body", err.Error())
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		// the same key must come with the same route, ledger and body
+		ledgerID, _ := c.Get("ledger_id")
+		fingerprint := sha256.New()
+		fmt.Fprintf(fingerprint, "%s %s %v\n", c.Request.Method, c.FullPath(), ledgerID)
+		fingerprint.Write(body)
+		requestHash := hex.EncodeToString(fingerprint.Sum(nil))
+
+		now := time.Now()
+		_, err = db.Exec(`
+			DELETE FROM swordfish.idempotency_keys
+			WHERE user_id = $1 AND (expires_at < $2 OR (response_status IS NULL AND created_at < $3))
+		`, userID, now, now.Add(-idempotencyPendingTimeout))
+		if err != nil {
+			RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			c.Abort()
+			return
+		}
+
+		result, err := db.Exec(`
+			INSERT INTO swordfish.idempotency_keys (user_id, key, request_hash, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id, key) DO NOTHING
+		`, userID, key, requestHash, now, now.Add(IdempotencyKeyDuration()))
+		if err != nil {
+			RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			c.Abort()
+			return
+		}
+
+		if inserted, _ := result.RowsAffected(); inserted == 0 {
+			replayIdempotentResponse(c, db, userID, key, requestHash)
+			c.Abort()
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			_, err = db.Exec(`DELETE FROM swordfish.idempotency_keys WHERE user_id = $1 AND key = $2`, userID, key)
+		} else {
+			_, err = db.Exec(`
+				UPDATE swordfish.idempotency_keys
+				SET response_status = $1, response_body = $2
+				WHERE user_id = $3 AND key = $4
+			`, recorder.Status(), recorder.body.Bytes(), userID, key)
+		}
+		if err != nil {
+			log.Printf("[middleware][idempotency] failed to store response: %v", err)
+		}
+	}
+}
+
+// replayIdempotentResponse answers a retry with the response stored for the key
+func replayIdempotentResponse(c *gin.Context, db *sql.DB, userID float64, key, requestHash string) {
+	var storedHash string
+	var status *int
+	var body []byte
+	err := db.QueryRow(`
+		SELECT request_hash, response_status, response_body
+		FROM swordfish.idempotency_keys
+		WHERE user_id = $1 AND key = $2
+	`, userID, key).Scan(&storedHash, &status, &body)
+	if err == sql.ErrNoRows {
+		// the first attempt failed and released the key in the meantime
+		RespondError(c, http.StatusConflict, "Request with this Idempotency-Key failed, retry it", "")
+		return
+	} else if err != nil {
+		RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+		return
+	}
+
+	if storedHash != requestHash {
+		RespondError(c, http.StatusConflict, "Idempotency-Key was already used with a different request", "")
+		return
+	}
+	if status == nil {
+		RespondError(c, http.StatusConflict, "Request with this Idempotency-Key is still in progress", "")
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(*status, "application/json; charset=utf-8", body)
+}
@@ -1,9 +1,13 @@
 package utils
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
@@ -17,9 +21,113 @@ func ErrorResponseUnauthorizedJwt(c *gin.Context, message string) {
 	})
 }
 
-func JWTAuth() gin.HandlerFunc {
-	secret := os.Getenv("JWT_SECRET")
+// AccessTokenDuration is the lifetime of an access token, JWT_EXPIRATION_MINUTES overrides the default
+func AccessTokenDuration() time.Duration {
+	duration := 15 * time.Minute // Default
+	if envDuration := os.Getenv("JWT_EXPIRATION_MINUTES"); envDuration != "" {
+		if minutes, err := strconv.Atoi(envDuration); err == nil && minutes > 0 {
+			duration = time.Duration(minutes) * time.Minute
+		}
+	}
+	return duration
+}
+
+// RefreshTokenDuration is the lifetime of a session, REFRESH_TOKEN_EXPIRATION_DAYS overrides the default
+func RefreshTokenDuration() time.Duration {
+	duration := 30 * 24 * time.Hour // Default
+	if envDuration := os.Getenv("REFRESH_TOKEN_EXPIRATION_DAYS"); envDuration != "" {
+		if days, err := strconv.Atoi(envDuration); err == nil && days > 0 {
+			duration = time.Duration(days) * 24 * time.Hour
+		}
+	}
+	return duration
+}
+
+// GenerateAccessToken signs a short-lived access token bound to a session through the jti claim
+func GenerateAccessToken(userID int, email, sessionID string) (string, error) {
+	return signTypedToken(tokenTypeAccess, jwt.MapClaims{
+		"sub":   userID,
+		"email": email,
+		"jti":   sessionID,
+		"scope": strings.Join(AllScopes, " "),
+		"exp":   time.Now().Add(AccessTokenDuration()).Unix(),
+	})
+}
+
+// mfaTokenDuration is how long the user has to enter the TOTP code after the password
+const mfaTokenDuration = 5 * time.Minute
+
+// GenerateMFAToken signs the challenge token returned by login when two-factor authentication is enabled.
+// It has no session, so JWTAuth rejects it as an access token.
+func GenerateMFAToken(userID int) (string, error) {
+	return signTypedToken(tokenTypeMFA, jwt.MapClaims{
+		"sub": userID,
+		"exp": time.Now().Add(mfaTokenDuration).Unix(),
+	})
+}
+
+// ParseMFAToken validates an MFA challenge token and returns its user id
+func ParseMFAToken(tokenString string) (int, error) {
+	claims, err := parseTypedToken(tokenString, tokenTypeMFA)
+	if err != nil {
+		return 0, err
+	}
+	sub, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, errors.New("invalid MFA token")
+	}
+	return int(sub), nil
+}
+
+// verificationTokenDuration is how long an email verification link stays valid
+const verificationTokenDuration = 24 * time.Hour
+
+// GenerateVerificationToken signs the token of an email verification link. It carries the email,
+// so the link stops working once the email of the user changes.
+func GenerateVerificationToken(userID int, email string) (string, error) {
+	return signTypedToken(tokenTypeVerify, jwt.MapClaims{
+		"sub":   userID,
+		"email": email,
+		"exp":   time.Now().Add(verificationTokenDuration).Unix(),
+	})
+}
 
+// ParseVerificationToken validates an email verification token and returns its user id and email
+func ParseVerificationToken(tokenString string) (int, string, error) {
+	claims, err := parseTypedToken(tokenString, tokenTypeVerify)
+	if err != nil {
+		return 0, "", err
+	}
+	sub, ok := claims["sub"].(float64)
+	email, _ := claims["email"].(string)
+	if !ok || email == "" {
+		return 0, "", errors.New("invalid verification token")
+	}
+	return int(sub), email, nil
+}
+
+// GenerateDownloadToken signs the token of a data export download link, valid until expiresAt
+func GenerateDownloadToken(exportID int, expiresAt time.Time) (string, error) {
+	return signTypedToken(tokenTypeDownload, jwt.MapClaims{
+		"sub": exportID,
+		"exp": expiresAt.Unix(),
+	})
+}
+
+// ParseDownloadToken validates a download token and returns its export id
+func ParseDownloadToken(tokenString string) (int, error) {
+	claims, err := parseTypedToken(tokenString, tokenTypeDownload)
+	if err != nil {
+		return 0, err
+	}
+	sub, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, errors.New("invalid download token")
+	}
+	return int(sub), nil
+}
+
+func JWTAuth(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 
@@ -30,32 +138,56 @@ func JWTAuth() gin.HandlerFunc {
 		}
 
 		parts := strings.Split(authHeader, " ")
+		if len(parts) == 2 && parts[0] == "ApiKey" {
+			apiKeyAuth(c, db, parts[1])
+			return
+		}
 		if len(parts) != 2 || parts[0] != "Bearer" {
 			ErrorResponseUnauthorizedJwt(c, "[middleware][jwt] Invalid or missing Bearer token")
 			c.Abort()
 			return
 		}
 
+		// only access tokens are accepted, MFA, verification and download tokens have another typ and aud
 		tokenString := parts[1]
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return []byte(secret), nil
-		})
-
+		claims, err := parseTypedToken(tokenString, tokenTypeAccess)
 		if err != nil {
 			ErrorResponseUnauthorizedJwt(c, "[middleware][jwt] Invalid token")
 			c.Abort()
 			return
 		}
-
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok || !token.Valid {
+		sub, subOK := claims["sub"].(float64)
+		email, emailOK := claims["email"].(string)
+		if !subOK || !emailOK {
 			ErrorResponseUnauthorizedJwt(c, "[middleware][jwt] Invalid token")
 			c.Abort()
 			return
 		}
 
-		c.Set("user_id", claims["sub"].(float64))
-		c.Set("email", claims["email"].(string))
+		// the session must still be active, logout revokes it before the token expires
+		sessionID, _ := claims["jti"].(string)
+		var active bool
+		err = db.QueryRow(`
+			SELECT revoked_at IS NULL AND expires_at > NOW()
+			FROM swordfish.sessions
+			WHERE id = $1
+		`, sessionID).Scan(&active)
+		if err != nil && err != sql.ErrNoRows {
+			RespondError(c, http.StatusInternalServerError, "Database error", err.Error())
+			c.Abort()
+			return
+		}
+		if !active {
+			ErrorResponseUnauthorizedJwt(c, "[middleware][jwt] Session has been revoked")
+			c.Abort()
+			return
+		}
+
+		c.Set("user_id", sub)
+		c.Set("email", email)
+		c.Set("session_id", sessionID)
+		scope, _ := claims["scope"].(string)
+		c.Set("scopes", ParseScopes(scope))
 		c.Next()
 	}
 }
@@ -0,0 +1,252 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is one key of JWT_KEY_DIR, keys without a private part only verify tokens
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+type keySet struct {
+	keys   map[string]*signingKey
+	active *signingKey
+}
+
+// signingKeys is nil while no key directory is configured, tokens are then signed with JWT_SECRET
+var signingKeys *keySet
+
+// LoadSigningKeys loads the RS256 and EdDSA keys of JWT_KEY_DIR. Every <kid>.pem file holds a private
+// key, or only a public key for a retired key that still verifies live tokens. New tokens are signed
+// with JWT_ACTIVE_KID, by default the last private key in name order, so rotating means adding a newer file.
+func LoadSigningKeys() error {
+	dir := os.Getenv("JWT_KEY_DIR")
+	if dir == "" {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	set := &keySet{keys: map[string]*signingKey{}}
+	for _, file := range files {
+		key, err := loadSigningKey(file)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		set.keys[key.kid] = key
+		if key.private != nil {
+			set.active = key
+		}
+	}
+
+	if kid := os.Getenv("JWT_ACTIVE_KID"); kid != "" {
+		set.active = set.keys[kid]
+	}
+	if set.active == nil || set.active.private == nil {
+		return errors.New("JWT_KEY_DIR has no private key to sign with")
+	}
+
+	signingKeys = set
+	return nil
+}
+
+func loadSigningKey(file string) (*signingKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{kid: strings.TrimSuffix(filepath.Base(file), ".pem")}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", parsed)
+	}
+	return key, nil
+}
+
+// signToken signs the claims with the active key, or with JWT_SECRET when no key directory is configured
+func signToken(claims jwt.MapClaims) (string, error) {
+	if signingKeys != nil {
+		token := jwt.NewWithClaims(signingKeys.active.method, claims)
+		token.Header["kid"] = signingKeys.active.kid
+		return token.SignedString(signingKeys.active.private)
+	}
+
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return "", errors.New("JWT secret is not set")
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+}
+
+// parseToken verifies a token. A token with a kid must use exactly the algorithm of that key.
+// A token without kid must be HS256 and is only accepted while no key directory is loaded,
+// so once JWT_KEY_DIR is configured JWT_SECRET can no longer mint valid tokens.
+// Clients holding an old access token renew it with their refresh token.
+func parseToken(tokenString string, options ...jwt.ParserOption) (*jwt.Token, error) {
+	options = append(options, jwt.WithExpirationRequired())
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if signingKeys == nil {
+			secret := os.Getenv("JWT_SECRET")
+			if kid != "" || token.Method != jwt.SigningMethodHS256 || secret == "" {
+				return nil, errors.New("unexpected signing method")
+			}
+			return []byte(secret), nil
+		}
+
+		key, ok := signingKeys.keys[kid]
+		if !ok {
+			return nil, errors.New("unknown key id")
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.public, nil
+	}, append(options, jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg(), jwt.SigningMethodHS256.Alg()}))...)
+}
+
+// Token types, carried in the typ claim so a token of one kind is never accepted as another
+const (
+	tokenTypeAccess   = "access"
+	tokenTypeMFA      = "mfa"
+	tokenTypeVerify   = "verify"
+	tokenTypeDownload = "download"
+)
+
+// tokenIssuer is the iss claim of every token, JWT_ISSUER overrides the default
+func tokenIssuer() string {
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "xwing"
+}
+
+// tokenAudience is the aud claim of a token type. Only access tokens carry the API audience,
+// JWT_AUDIENCE overrides it; the other tokens are for xwing itself and get an audience of their own,
+// so services verifying against the JWKS reject them.
+func tokenAudience(typ string) string {
+	if typ == tokenTypeAccess {
+		if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+			return audience
+		}
+		return "xwing-api"
+	}
+	return tokenIssuer() + ":" + typ
+}
+
+// signTypedToken signs the claims as a token of type typ, adding the typ, iss, aud and iat claims
+func signTypedToken(typ string, claims jwt.MapClaims) (string, error) {
+	claims["typ"] = typ
+	claims["iss"] = tokenIssuer()
+	claims["aud"] = tokenAudience(typ)
+	claims["iat"] = time.Now().Unix()
+	return signToken(claims)
+}
+
+// parseTypedToken verifies a token and requires the typ, iss and aud claims of type typ
+func parseTypedToken(tokenString, typ string) (jwt.MapClaims, error) {
+	token, err := parseToken(tokenString, jwt.WithIssuer(tokenIssuer()), jwt.WithAudience(tokenAudience(typ)))
+	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["typ"] != typ {
+		return nil, fmt.Errorf("invalid %s token", typ)
+	}
+	return claims, nil
+}
+
+// JWK is a public key in the JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// PublicJWKS returns the public keys other services can verify tokens with, retired keys included
+func PublicJWKS() []JWK {
+	jwks := []JWK{}
+	if signingKeys == nil {
+		return jwks
+	}
+
+	kids := make([]string, 0, len(signingKeys.keys))
+	for kid := range signingKeys.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	for _, kid := range kids {
+		key := signingKeys.keys[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
@@ -0,0 +1,45 @@
+package utils
+
+import "time"
+
+// NthOccurrence returns the date of the n-th (zero based) occurrence of a recurring rule.
+// Monthly and yearly rules keep the day of month of start, clamped to the end of shorter months.
+func NthOccurrence(start time.Time, frequency string, interval, n int) time.Time {
+	if interval < 1 {
+		interval = 1
+	}
+	switch frequency {
+	case "daily":
+		return start.AddDate(0, 0, n*interval)
+	case "weekly":
+		return start.AddDate(0, 0, 7*n*interval)
+	case "monthly":
+		return addMonthsClamped(start, n*interval)
+	case "yearly":
+		return addMonthsClamped(start, 12*n*interval)
+	}
+	return start
+}
+
+// NextOccurrence returns the occurrence that follows the already materialized ones,
+// or nil when the rule has reached its end date or count.
+func NextOccurrence(start time.Time, frequency string, interval, occurrences int, endDate *time.Time, count *int) *time.Time {
+	if count != nil && occurrences >= *count {
+		return nil
+	}
+	next := NthOccurrence(start, frequency, interval, occurrences)
+	if endDate != nil && next.After(*endDate) {
+		return nil
+	}
+	return &next
+}
+
+func addMonthsClamped(t time.Time, months int) time.Time {
+	firstOfMonth := time.Date(t.Year(), t.Month()+time.Month(months), 1, 0, 0, 0, 0, t.Location())
+	lastDay := firstOfMonth.AddDate(0, 1, -1).Day()
+	day := t.Day()
+	if day > lastDay {
+		day = lastDay
+	}
+	return time.Date(firstOfMonth.Year(), firstOfMonth.Month(), day, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestAddMonthsClamped(t *testing.T) {
+	tests := []struct {
+		name   string
+		start  time.Time
+		months int
+		want   time.Time
+	}{
+		{"jan 31 to feb in a common year", date(2023, time.January, 31), 1, date(2023, time.February, 28)},
+		{"jan 31 to feb in a leap year", date(2024, time.January, 31), 1, date(2024, time.February, 29)},
+		{"jan 31 to apr", date(2024, time.January, 31), 3, date(2024, time.April, 30)},
+		{"jan 31 to mar keeps the day", date(2024, time.January, 31), 2, date(2024, time.March, 31)},
+		{"across the year", date(2024, time.November, 30), 3, date(2025, time.February, 28)},
+		{"feb 29 to next year", date(2024, time.February, 29), 12, date(2025, time.February, 28)},
+		{"no months", date(2024, time.May, 15), 0, date(2024, time.May, 15)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addMonthsClamped(tt.start, tt.months); !got.Equal(tt.want) {
+				t.Errorf("addMonthsClamped(%s, %d) = %s, want %s", tt.start.Format("2006-01-02"), tt.months, got.Format("2006-01-02"), tt.want.Format("2006-01-02"))
+			}
+		})
+	}
+}
+
+func TestNthOccurrence(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     time.Time
+		frequency string
+		interval  int
+		n         int
+		want      time.Time
+	}{
+		{"first occurrence is the start", date(2024, time.January, 31), "monthly", 1, 0, date(2024, time.January, 31)},
+		{"daily", date(2024, time.February, 27), "daily", 1, 3, date(2024, time.March, 1)},
+		{"weekly every two weeks", date(2024, time.January, 1), "weekly", 2, 2, date(2024, time.January, 29)},
+		{"monthly clamps to feb 29", date(2024, time.January, 31), "monthly", 1, 1, date(2024, time.February, 29)},
+		{"monthly returns to day 31 after feb", date(2024, time.January, 31), "monthly", 1, 2, date(2024, time.March, 31)},
+		{"monthly every three months", date(2024, time.January, 31), "monthly", 3, 1, date(2024, time.April, 30)},
+		{"monthly zero interval counts as one", date(2024, time.January, 15), "monthly", 0, 2, date(2024, time.March, 15)},
+		{"yearly from feb 29 clamps", date(2024, time.February, 29), "yearly", 1, 1, date(2025, time.February, 28)},
+		{"yearly back to a leap year", date(2024, time.February, 29), "yearly", 1, 4, date(2028, time.February, 29)},
+		{"yearly every two years", date(2023, time.June, 10), "yearly", 2, 2, date(2027, time.June, 10)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NthOccurrence(tt.start, tt.frequency, tt.interval, tt.n); !got.Equal(tt.want) {
+				t.Errorf("NthOccurrence(%s, %s, %d, %d) = %s, want %s", tt.start.Format("2006-01-02"), tt.frequency, tt.interval, tt.n, got.Format("2006-01-02"), tt.want.Format("2006-01-02"))
+			}
+		})
+	}
+}
+
+func TestNextOccurrenceStopsAtEnd(t *testing.T) {
+	start := date(2024, time.January, 31)
+	count := 2
+	if next := NextOccurrence(start, "monthly", 1, 2, nil, &count); next != nil {
+		t.Errorf("NextOccurrence after count = %s, want nil", next.Format("2006-01-02"))
+	}
+	endDate := date(2024, time.March, 30)
+	if next := NextOccurrence(start, "monthly", 1, 2, &endDate, nil); next != nil {
+		t.Errorf("NextOccurrence after end date = %s, want nil", next.Format("2006-01-02"))
+	}
+	if next := NextOccurrence(start, "monthly", 1, 1, &endDate, &count); next == nil || !next.Equal(date(2024, time.February, 29)) {
+		t.Errorf("NextOccurrence = %v, want 2024-02-29", next)
+	}
+}
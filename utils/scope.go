@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Scopes limit what a token may do, routes declare the scope they need with RequireScope
+const (
+	ScopeTransactionsRead  = "transactions:read"
+	ScopeTransactionsWrite = "transactions:write"
+	ScopeReportsRead       = "reports:read"
+	ScopeBudgetsWrite      = "budgets:write"
+	ScopeAssetsRead        = "assets:read"
+	ScopeAssetsWrite       = "assets:write"
+)
+
+// AllScopes are granted to login sessions and to API keys created without explicit scopes
+var AllScopes = []string{
+	ScopeTransactionsRead,
+	ScopeTransactionsWrite,
+	ScopeReportsRead,
+	ScopeBudgetsWrite,
+	ScopeAssetsRead,
+	ScopeAssetsWrite,
+}
+
+// ReadScopes are the scopes that never change data
+var ReadScopes = []string{
+	ScopeTransactionsRead,
+	ScopeReportsRead,
+	ScopeAssetsRead,
+}
+
+// ParseScopes splits a space separated scope claim, an empty claim grants no scope
+// so a token that forgets the claim cannot reach scoped routes
+func ParseScopes(claim string) []string {
+	return strings.Fields(claim)
+}
+
+// RequireScope aborts with 403 unless the authenticated token carries the scope
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, _ := c.Get("scopes")
+		if granted, _ := scopes.([]string); !slices.Contains(granted, scope) {
+			RespondError(c, http.StatusForbidden, "Failed", "[middleware][scope] Token is missing the "+scope+" scope")
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
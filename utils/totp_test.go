@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 key of the RFC 6238 test vectors, "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	key, err := totpEncoding.DecodeString(rfc6238Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the RFC lists 8 digit codes, the last 6 digits are the 6 digit codes
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+		step, ok := ValidateTOTP(rfc6238Secret, tt.want, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/totpPeriod {
+			t.Errorf("ValidateTOTP at %d = %d, %v, want %d, true", tt.unix, step, ok, tt.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTPSkew(t *testing.T) {
+	// 1111111109 is step 37037036, its code is 081804
+	now := time.Unix(1111111109, 0)
+	tests := []struct {
+		name     string
+		secret   string
+		at       time.Time
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"same step", rfc6238Secret, now, "081804", 37037036, true},
+		{"one step later", rfc6238Secret, now.Add(totpPeriod * time.Second), "081804", 37037036, true},
+		{"one step earlier", rfc6238Secret, now.Add(-totpPeriod * time.Second), "081804", 37037036, true},
+		{"two steps later", rfc6238Secret, now.Add(2 * totpPeriod * time.Second), "081804", 0, false},
+		{"two steps earlier", rfc6238Secret, now.Add(-2 * totpPeriod * time.Second), "081804", 0, false},
+		{"spaces are ignored", rfc6238Secret, now, " 081 804 ", 37037036, true},
+		{"wrong code", rfc6238Secret, now, "081805", 0, false},
+		{"too short", rfc6238Secret, now, "81804", 0, false},
+		{"lowercase secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", now, "081804", 37037036, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTP(tt.secret, tt.code, tt.at)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("ValidateTOTP = %d, %v, want %d, %v", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+	now := time.Now()
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ValidateTOTP(secret, code, now); !ok {
+		t.Fatalf("generated code %s does not validate", code)
+	}
+}